@@ -3,14 +3,27 @@ package main
 import (
 	"gossip/pkg/kube"
 	"gossip/pkg/kube/clifactory"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// terminationGracePeriod is how long pods are given to drain, flush the spool
+// and leave; the worker derives its drain timeout from it.
+const terminationGracePeriod = 60 * time.Second
+
 func main() {
 	clifactory.Resources{
-		"service":    kube.ServiceHeadless("gossip-members", "gossip", v1.ServicePort{Name: "http", Protocol: v1.ProtocolTCP, Port: 7946, TargetPort: intstr.FromInt32(7946)}),
-		"deployment": kube.Deployment("gossip", "sf-microk8s.hawk-bluegill.ts.net:32000/gossip:latest", kube.OptDeploymentPort("http", 7946, v1.ProtocolTCP)),
+		"service": kube.ServiceHeadless("gossip-members", "gossip", v1.ServicePort{Name: "http", Protocol: v1.ProtocolTCP, Port: 7946, TargetPort: intstr.FromInt32(7946)}),
+		"deployment": kube.Deployment(
+			"gossip",
+			"sf-microk8s.hawk-bluegill.ts.net:32000/gossip:latest",
+			kube.OptDeploymentPort("http", 7946, v1.ProtocolTCP),
+			kube.OptDeploymentPort("admin", 8080, v1.ProtocolTCP),
+			kube.OptDeploymentHTTPProbes("admin", "/healthz", "/readyz"),
+			kube.OptDeploymentArgs("-termination-grace-period="+terminationGracePeriod.String()),
+			kube.OptDeploymentTerminationGracePeriod(int64(terminationGracePeriod.Seconds())),
+		),
 	}.Main()
 }
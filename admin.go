@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// startAdmin starts the admin http servers in the background.
+//
+// The admin server serves the probes, metrics and read-only endpoints, and
+// is reachable by the kubelet and scrapers; endpoints that change the node
+// or the cluster are only served by the control server, which listens on
+// loopback by default, such that reaching the probes doesn't allow
+// rotating keys, draining nodes, firing events and queries, or writing
+// shared state.
+func (w *worker) startAdmin(addr, controlAddr string) {
+	mux := http.NewServeMux()
+	control := http.NewServeMux()
+	mux.Handle("GET /metrics", w.metrics.registry.Handler())
+	w.registerHealthHandlers(mux)
+	w.registerDebugHandlers(mux)
+	w.registerKeyringHandlers(mux, control)
+	w.registerDrainHandlers(control)
+	w.registerEventHandlers(mux, control)
+	w.registerQueryHandlers(control)
+	w.registerKVHandlers(mux, control)
+	w.registerEntityListHandlers(mux)
+	w.registerStandbyHandlers(mux)
+	w.admin = w.serveAdmin("admin", addr, mux)
+	w.control = w.serveAdmin("control", controlAddr, control)
+}
+
+// serveAdmin starts serving a mux in the background.
+func (w *worker) serveAdmin(name, addr string, mux *http.ServeMux) *http.Server {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("admin server failed", slog.String("hostname", w.hostname), slog.String("server", name), slog.Any("err", err))
+		}
+	}()
+	return server
+}
+
+// stopAdmin gracefully shuts down the admin http servers.
+func (w *worker) stopAdmin() {
+	timeoutContext, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range []*http.Server{w.admin, w.control} {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(timeoutContext); err != nil {
+			slog.Error("failed to shutdown admin server", slog.String("hostname", w.hostname), slog.String("addr", server.Addr), slog.Any("err", err))
+		}
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, statusCode int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	_ = json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"gossip/pkg/nodemeta"
+	"log/slog"
+	"slices"
+
+	"github.com/hashicorp/memberlist"
+)
+
+var (
+	_ memberlist.AliveDelegate = (*worker)(nil)
+	_ memberlist.MergeDelegate = (*worker)(nil)
+)
+
+// NotifyAlive implements memberlist.AliveDelegate and rejects peers
+// that should not be considered part of the cluster.
+//
+// NotifyAlive is called with the memberlist node lock held, so it
+// must not call back into the memberlist.
+func (w *worker) NotifyAlive(peer *memberlist.Node) error {
+	if err := w.admit(peer); err != nil {
+		slog.Warn("rejected peer", slog.String("hostname", w.hostname), slog.String("member-name", peer.Name), slog.String("member-addr", peer.Address()), slog.Any("err", err))
+		return err
+	}
+	return nil
+}
+
+// NotifyMerge implements memberlist.MergeDelegate and cancels
+// a join if any of the remote cluster's peers would be rejected.
+func (w *worker) NotifyMerge(peers []*memberlist.Node) error {
+	seen := make(map[string]string, len(peers))
+	for _, peer := range peers {
+		if addr, ok := seen[peer.Name]; ok && addr != peer.Address() {
+			err := fmt.Errorf("duplicate member name %q at %s and %s", peer.Name, addr, peer.Address())
+			slog.Warn("rejected merge", slog.String("hostname", w.hostname), slog.String("member-name", peer.Name), slog.Any("err", err))
+			return err
+		}
+		seen[peer.Name] = peer.Address()
+		if err := w.admit(peer); err != nil {
+			slog.Warn("rejected merge", slog.String("hostname", w.hostname), slog.String("member-name", peer.Name), slog.String("member-addr", peer.Address()), slog.Any("err", err))
+			return err
+		}
+	}
+	return nil
+}
+
+// admit returns an error if a peer has a different cluster id, an incompatible
+// protocol or build version, or reuses the name of a different live member.
+func (w *worker) admit(peer *memberlist.Node) error {
+	meta, err := nodemeta.Decode(peer.Meta)
+	if err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	local := w.localMeta()
+	if meta.ClusterID != local.ClusterID {
+		return fmt.Errorf("cluster id mismatch; %q != %q", meta.ClusterID, local.ClusterID)
+	}
+	if peer.PMin > w.protocolVersion || peer.PMax < w.protocolVersion {
+		return fmt.Errorf("incompatible protocol version; %d not in [%d, %d]", w.protocolVersion, peer.PMin, peer.PMax)
+	}
+	if !nodemeta.CompatibleBuildVersions(meta.BuildVersion, local.BuildVersion) {
+		return fmt.Errorf("incompatible build version; %q vs. %q", meta.BuildVersion, local.BuildVersion)
+	}
+	if addr, ok := w.peerAddress(peer.Name); ok && addr != peer.Address() {
+		return fmt.Errorf("duplicate member name; %q is already at %s", peer.Name, addr)
+	}
+	return nil
+}
+
+// peerAddress returns the address of a live member by name.
+func (w *worker) peerAddress(name string) (addr string, ok bool) {
+	w.peersMu.Lock()
+	defer w.peersMu.Unlock()
+	addr, ok = w.peers[name]
+	return
+}
+
+// numPeers returns the number of live members, including this node.
+func (w *worker) numPeers() int {
+	w.peersMu.Lock()
+	defer w.peersMu.Unlock()
+	return max(len(w.peers), 1)
+}
+
+// peerNames returns the names of live members, including this node, sorted.
+func (w *worker) peerNames() []string {
+	w.peersMu.Lock()
+	defer w.peersMu.Unlock()
+	names := make([]string, 0, len(w.peers))
+	for name := range w.peers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// trackPeer records or forgets the address of a member.
+func (w *worker) trackPeer(n *memberlist.Node, alive bool) {
+	w.peersMu.Lock()
+	defer w.peersMu.Unlock()
+	if w.peers == nil {
+		w.peers = make(map[string]string)
+	}
+	if alive {
+		w.peers[n.Name] = n.Address()
+	} else {
+		delete(w.peers, n.Name)
+	}
+}
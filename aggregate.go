@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gossip/pkg/types"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Push modes.
+const (
+	pushModeAll   = "all"
+	pushModeDelta = "delta"
+)
+
+// pushState holds what the worker remembers between pushes, that is the last
+// value pushed per entity for delta pushes, and the values accumulated over
+// the current aggregation window.
+//
+// Entities are keyed by their fetched id rather than the name the pipeline
+// pushes them under, such that the state is retained and handed off by the
+// ids entities are assigned by.
+type pushState struct {
+	mu            sync.Mutex
+	last          map[string]int64
+	window        map[string]*windowAggregate
+	windowStarted time.Time
+}
+
+// windowAggregate is an entity's aggregate over the current
+// window, along with the entity name it is pushed under.
+type windowAggregate struct {
+	Entity string `json:"entity"`
+	types.Aggregate
+}
+
+// Changed returns the values that differ from the last values pushed.
+func (ps *pushState) Changed(values map[string]int64) map[string]int64 {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	output := make(map[string]int64, len(values))
+	for entity, value := range values {
+		if last, ok := ps.last[entity]; !ok || last != value {
+			output[entity] = value
+		}
+	}
+	return output
+}
+
+// Pushed records values as pushed.
+func (ps *pushState) Pushed(values map[string]int64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.last == nil {
+		ps.last = make(map[string]int64, len(values))
+	}
+	for entity, value := range values {
+		ps.last[entity] = value
+	}
+}
+
+// DropUnchanged removes aggregates whose values all equal the last value pushed.
+func (ps *pushState) DropUnchanged(aggregates map[string]windowAggregate) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for entity, a := range aggregates {
+		if last, ok := ps.last[entity]; ok && a.Min == last && a.Max == last {
+			delete(aggregates, entity)
+		}
+	}
+}
+
+// Accumulate adds values to the current aggregation window, given the
+// entity names the pipeline renamed entities to.
+func (ps *pushState) Accumulate(values map[string]int64, names map[string]string, now time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.window == nil {
+		ps.window = make(map[string]*windowAggregate, len(values))
+		ps.windowStarted = now
+	}
+	for entity, value := range values {
+		a, ok := ps.window[entity]
+		if !ok {
+			a = new(windowAggregate)
+			ps.window[entity] = a
+		}
+		a.Entity = entityName(names, entity)
+		a.Add(value)
+	}
+}
+
+// Take returns and resets the current aggregation window if it
+// has been open for at least `window`, or if forced.
+func (ps *pushState) Take(now time.Time, window time.Duration, force bool) (output map[string]windowAggregate) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.window == nil || (!force && now.Sub(ps.windowStarted) < window) {
+		return
+	}
+	output = make(map[string]windowAggregate, len(ps.window))
+	for entity, a := range ps.window {
+		output[entity] = *a
+	}
+	ps.window = nil
+	return
+}
+
+// Export returns the last values pushed and the aggregates
+// of the current window for the given entities.
+func (ps *pushState) Export(entities []string) (lasts map[string]int64, window map[string]windowAggregate) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	lasts = make(map[string]int64)
+	window = make(map[string]windowAggregate)
+	for _, e := range entities {
+		if last, ok := ps.last[e]; ok {
+			lasts[e] = last
+		}
+		if a, ok := ps.window[e]; ok {
+			window[e] = *a
+		}
+	}
+	return
+}
+
+// Import records last values pushed by the previous owner of entities,
+// unless this node has since pushed values of its own, and merges the
+// previous owner's aggregates into the current window, keeping the last
+// values this node accumulated since.
+func (ps *pushState) Import(lasts map[string]int64, window map[string]windowAggregate, now time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.last == nil {
+		ps.last = make(map[string]int64, len(lasts))
+	}
+	for entity, value := range lasts {
+		if _, ok := ps.last[entity]; !ok {
+			ps.last[entity] = value
+		}
+	}
+	if len(window) > 0 && ps.window == nil {
+		ps.window = make(map[string]*windowAggregate, len(window))
+		ps.windowStarted = now
+	}
+	for entity, previous := range window {
+		a, ok := ps.window[entity]
+		if !ok || a.Count == 0 {
+			previous := previous
+			ps.window[entity] = &previous
+			continue
+		}
+		a.Min = min(a.Min, previous.Min)
+		a.Max = max(a.Max, previous.Max)
+		a.Count += previous.Count
+	}
+}
+
+// Retain forgets everything about entities that aren't in a given set,
+// such that an entity that is handed off and later regained is pushed in full.
+func (ps *pushState) Retain(entities []string) {
+	owned := make(map[string]struct{}, len(entities))
+	for _, e := range entities {
+		owned[e] = struct{}{}
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for entity := range ps.last {
+		if _, ok := owned[entity]; !ok {
+			delete(ps.last, entity)
+		}
+	}
+	for entity := range ps.window {
+		if _, ok := owned[entity]; !ok {
+			delete(ps.window, entity)
+		}
+	}
+}
+
+// flushAggregates pushes the aggregation window once it has elapsed, or
+// immediately if forced, in batches of at most the batch size.
+func (w *worker) flushAggregates(ctx context.Context, ring ringState, now time.Time, force bool) error {
+	aggregates := w.pushState.Take(now, *aggregateWindow, force)
+	if *pushMode == pushModeDelta {
+		w.pushState.DropUnchanged(aggregates)
+	}
+	if len(aggregates) == 0 {
+		return nil
+	}
+	entities := make([]string, 0, len(aggregates))
+	for entity := range aggregates {
+		entities = append(entities, entity)
+	}
+	slices.Sort(entities)
+
+	var failed int
+	batches := splitBatches(w.tickSeq.Add(1), entities, *batchSize, w.epoch(ring))
+	for _, b := range batches {
+		submission := w.newSubmission(b, nil)
+		for _, e := range b.Entities {
+			a := aggregates[e].Aggregate
+			submission.Values = append(submission.Values, types.MetricSinkSubmissionValue{
+				Entity:    aggregates[e].Entity,
+				Hostname:  w.hostname,
+				Value:     a.Last,
+				Epoch:     b.Epoch,
+				Aggregate: &a,
+			})
+		}
+		accepted, err := w.submitBatch(ctx, submission)
+		if err != nil {
+			failed++
+		}
+		if *pushMode != pushModeDelta {
+			continue
+		}
+		lasts := make(map[string]int64, len(b.Entities))
+		for _, e := range b.Entities {
+			if accepted[aggregates[e].Entity] {
+				lasts[e] = aggregates[e].Last
+			}
+		}
+		w.pushState.Pushed(lasts)
+	}
+	slog.Info("pushed aggregates", slog.String("hostname", w.hostname), slog.Int("entity-count", len(entities)), slog.Int("failed-batches", failed))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d aggregate batches failed", failed, len(batches))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"gossip/pkg/types"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testRenamePipeline = "stages: [{rename: {regex: '^prices/(.*)$', replacement: 'etf.$1'}}]"
+
+func Test_pushState_rename(t *testing.T) {
+	w := newTestWorker(t, "a", testRenamePipeline)
+	values, names := w.transform(map[string]int64{"prices/AAPL": 10})
+	if values["prices/AAPL"] != 10 || names["prices/AAPL"] != "etf.AAPL" {
+		t.Fatalf("unexpected transform: %v %v", values, names)
+	}
+	if pushed := byEntityName(values, names); pushed["etf.AAPL"] != 10 {
+		t.Fatalf("expected values to be pushed under the renamed entity, was: %v", pushed)
+	}
+
+	w.pushState.Pushed(values)
+	w.pushState.Accumulate(values, names, time.Unix(1000, 0))
+	w.pushState.Retain([]string{"prices/AAPL"})
+	if changed := w.pushState.Changed(values); len(changed) != 0 {
+		t.Fatalf("expected the last value pushed to survive a resync, was changed: %v", changed)
+	}
+	aggregates := w.pushState.Take(time.Unix(1000, 0), time.Minute, true)
+	if a := aggregates["prices/AAPL"]; a.Entity != "etf.AAPL" || a.Count != 1 || a.Last != 10 {
+		t.Fatalf("expected the window to survive a resync, was: %+v", aggregates)
+	}
+
+	w.pushState.Accumulate(values, names, time.Unix(1000, 0))
+	w.pushState.Retain(nil)
+	if changed := w.pushState.Changed(values); len(changed) != 1 {
+		t.Fatalf("expected the state of entities no longer owned to be forgotten, was: %v", changed)
+	}
+	if aggregates := w.pushState.Take(time.Unix(1000, 0), time.Minute, true); len(aggregates) != 0 {
+		t.Fatalf("expected the window of entities no longer owned to be forgotten, was: %+v", aggregates)
+	}
+}
+
+func Test_getAndPushBatch_delta(t *testing.T) {
+	setFlag(t, pushMode, pushModeDelta)
+	setFlag(t, upstreamAttempts, 1)
+	w := newTestWorker(t, "a", testRenamePipeline)
+	startTestDataPlane(t, w, map[string]int64{"AAPL": 10, "MSFT": 20}, nil)
+	w.sources[0].Name = "prices"
+
+	var pushed [][]string
+	status := http.StatusOK
+	startTestMetricSink(t, w, func(submission types.MetricSinkSubmission) (response types.MetricSinkSubmitResponse, _ int) {
+		var entities []string
+		for _, v := range submission.Values {
+			entities = append(entities, v.Entity)
+			if v.Entity == "etf.AAPL" && len(pushed) == 0 {
+				response.Rejected = append(response.Rejected, types.MetricSinkRejectedValue{Entity: v.Entity, Reason: "stale epoch"})
+			}
+		}
+		slices.Sort(entities)
+		pushed = append(pushed, entities)
+		return response, status
+	})
+
+	b := batch{Entities: []string{"prices/AAPL", "prices/MSFT"}}
+	if res := w.getAndPushBatch(context.Background(), b); res.FetchErr != nil || res.PushErr != nil {
+		t.Fatalf("expected errs to be unset, was: %v %v", res.FetchErr, res.PushErr)
+	}
+	if res := w.getAndPushBatch(context.Background(), b); res.PushErr != nil {
+		t.Fatalf("expected err to be unset, was: %v", res.PushErr)
+	}
+	if len(pushed) != 2 || !slices.Equal(pushed[1], []string{"etf.AAPL"}) {
+		t.Fatalf("expected the rejected value to be pushed again, and only it, was: %v", pushed)
+	}
+
+	w.pushState.Retain(nil)
+	status = http.StatusBadRequest
+	if res := w.getAndPushBatch(context.Background(), b); res.PushErr == nil {
+		t.Fatalf("expected err to be set")
+	}
+	status = http.StatusOK
+	if res := w.getAndPushBatch(context.Background(), b); res.PushErr != nil {
+		t.Fatalf("expected err to be unset, was: %v", res.PushErr)
+	}
+	if len(pushed) != 4 || !slices.Equal(pushed[3], []string{"etf.AAPL", "etf.MSFT"}) {
+		t.Fatalf("expected the values of a failed push to be pushed again, was: %v", pushed)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gossip/pkg/types"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// batch is a slice of owned entities fetched and pushed together.
+type batch struct {
+	Tick     uint64
+	Index    int
+	Entities []string
+	Epoch    types.Epoch
+}
+
+// batchResult is the outcome of fetching and pushing a batch.
+type batchResult struct {
+	batch
+	Data     types.DataPlaneResponse
+	FetchErr error
+	PushErr  error
+}
+
+// Err returns the batch error, if any.
+func (br batchResult) Err() error {
+	if br.FetchErr != nil {
+		return fmt.Errorf("batch %d: fetch: %w", br.Index, br.FetchErr)
+	}
+	if br.PushErr != nil {
+		return fmt.Errorf("batch %d: push: %w", br.Index, br.PushErr)
+	}
+	return nil
+}
+
+// splitBatches splits entities into batches of at most `size` entities.
+func splitBatches(tick uint64, entities []string, size int, epoch types.Epoch) (output []batch) {
+	size = max(size, 1)
+	for index := 0; len(entities) > 0; index++ {
+		n := min(size, len(entities))
+		output = append(output, batch{Tick: tick, Index: index, Entities: entities[:n], Epoch: epoch})
+		entities = entities[n:]
+	}
+	return
+}
+
+// getAndPushEntities fetches entities owned in a given ring in batches
+// with bounded parallelism, pushing each batch as soon as it is fetched.
+//
+// It returns the merged data for every batch that was fetched, and an error
+// describing the batches that failed; a failed batch does not stop the others.
+func (w *worker) getAndPushEntities(ctx context.Context, tick uint64, ring ringState, entities ...string) (merged types.DataPlaneResponse, err error) {
+	merged.Entities = make(map[string]int64, len(entities))
+	batches := splitBatches(tick, sortBySource(entities), *batchSize, w.epoch(ring))
+
+	work := make(chan batch)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	for x := 0; x < min(max(*fetchParallelism, 1), len(batches)); x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range work {
+				results <- w.getAndPushBatch(ctx, b)
+			}
+		}()
+	}
+	go func() {
+		defer close(work)
+		for _, b := range batches {
+			select {
+			case work <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs []error
+	var completed int
+	for res := range results {
+		completed++
+		for entity, value := range res.Data.Entities {
+			merged.Entities[entity] = value
+		}
+		if batchErr := res.Err(); batchErr != nil {
+			slog.Error("batch failed", slog.String("hostname", w.hostname), slog.Int("batch", res.Index), slog.Int("entity-count", len(res.Entities)), slog.Any("err", batchErr))
+			errs = append(errs, batchErr)
+		}
+	}
+	failed := len(errs)
+	if skipped := len(batches) - completed; skipped > 0 {
+		failed += skipped
+		errs = append(errs, fmt.Errorf("%d batches skipped: %w", skipped, ctx.Err()))
+	}
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d batches failed: %w", failed, len(batches), errors.Join(errs...))
+	}
+	return
+}
+
+// idempotencyKey returns the idempotency key for a batch's submission.
+//
+// The key is stable for the lifetime of the submission, so retries and
+// spool replays of the same batch are applied by the metric-sink at most once.
+func (w *worker) idempotencyKey(b batch) string {
+	return fmt.Sprintf("%s/%d/%d", w.hostname, b.Tick, b.Index)
+}
+
+// getAndPushBatch fetches and pushes a single batch.
+func (w *worker) getAndPushBatch(ctx context.Context, b batch) (res batchResult) {
+	res.batch = b
+	res.Data, res.FetchErr = w.getEntityData(ctx, b.Entities...)
+	w.metrics.fetches.Inc(result(res.FetchErr))
+	if res.FetchErr != nil {
+		return
+	}
+	w.counters.Add(counterEntitiesFetched, int64(len(res.Data.Entities)))
+	values, names := w.transform(res.Data.Entities)
+	if *aggregateWindow > 0 {
+		w.pushState.Accumulate(values, names, time.Now())
+		w.metrics.pushValues.Add(float64(len(values)), "aggregated")
+		return
+	}
+	if *pushMode == pushModeDelta {
+		changed := w.pushState.Changed(values)
+		w.metrics.pushValues.Add(float64(len(values)-len(changed)), "unchanged")
+		values = changed
+		if len(values) == 0 {
+			return
+		}
+	}
+	var accepted map[string]bool
+	accepted, res.PushErr = w.submitBatch(ctx, w.newSubmission(b, byEntityName(values, names)))
+	if *pushMode == pushModeDelta {
+		pushed := make(map[string]int64, len(values))
+		for id, value := range values {
+			if accepted[entityName(names, id)] {
+				pushed[id] = value
+			}
+		}
+		w.pushState.Pushed(pushed)
+	}
+	return
+}
+
+// submitBatch submits a batch's submission, recording the push result, and
+// returns the entities the metric-sink accepted.
+//
+// Values that are spooled, or that the metric-sink rejects, aren't accepted
+// yet, such that delta pushes push them again rather than skip them as
+// unchanged. The submission is queued for the additional sinks regardless
+// of the metric-sink result.
+func (w *worker) submitBatch(ctx context.Context, submission types.MetricSinkSubmission) (accepted map[string]bool, err error) {
+	if w.fanout != nil {
+		w.fanout.Write(time.Now(), submission)
+	}
+	if *metricSinkURL == "" {
+		return acceptedEntities(submission, types.MetricSinkSubmitResponse{}), nil
+	}
+	response, spooled, err := w.submit(ctx, submission)
+	switch {
+	case err != nil:
+		w.metrics.pushes.Inc("failure")
+		w.counters.Add(counterPushFailures, 1)
+	case spooled:
+		w.metrics.pushes.Inc("spooled")
+	default:
+		w.metrics.pushes.Inc("success")
+		w.metrics.lastPushSuccess.Set(float64(time.Now().Unix()))
+	}
+	if err == nil {
+		w.metrics.pushValues.Add(float64(len(submission.Values)), "submitted")
+		w.counters.Add(counterValuesPushed, int64(len(submission.Values)))
+	}
+	if err != nil || spooled {
+		return nil, err
+	}
+	return acceptedEntities(submission, response), nil
+}
+
+// acceptedEntities returns the entities of a submission that the metric-sink didn't reject.
+func acceptedEntities(submission types.MetricSinkSubmission, response types.MetricSinkSubmitResponse) map[string]bool {
+	accepted := make(map[string]bool, len(submission.Values))
+	for _, v := range submission.Values {
+		accepted[v.Entity] = true
+	}
+	for _, r := range response.Rejected {
+		delete(accepted, r.Entity)
+	}
+	return accepted
+}
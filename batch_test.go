@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"gossip/pkg/types"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_splitBatches(t *testing.T) {
+	epoch := types.Epoch{View: 1, Incarnation: 2, Ring: 3}
+	batches := splitBatches(7, []string{"a", "b", "c", "d", "e"}, 2, epoch)
+	if len(batches) != 3 {
+		t.Fatalf("expected 3 batches, was: %+v", batches)
+	}
+	for index, b := range batches {
+		if b.Tick != 7 || b.Index != index || b.Epoch != epoch {
+			t.Fatalf("unexpected batch %d: %+v", index, b)
+		}
+	}
+	if !slices.Equal(batches[0].Entities, []string{"a", "b"}) || !slices.Equal(batches[1].Entities, []string{"c", "d"}) || !slices.Equal(batches[2].Entities, []string{"e"}) {
+		t.Fatalf("expected batches of at most 2 entities in order, was: %+v", batches)
+	}
+
+	if batches := splitBatches(7, []string{"a", "b"}, 2, epoch); len(batches) != 1 {
+		t.Fatalf("expected entities at the size limit to fit a single batch, was: %+v", batches)
+	}
+	if batches := splitBatches(7, []string{"a", "b"}, 0, epoch); len(batches) != 2 {
+		t.Fatalf("expected a size below 1 to split single entities, was: %+v", batches)
+	}
+	if batches := splitBatches(7, nil, 2, epoch); len(batches) != 0 {
+		t.Fatalf("expected no batches without entities, was: %+v", batches)
+	}
+}
+
+func Test_getAndPushEntities_partialFailure(t *testing.T) {
+	setFlag(t, batchSize, 2)
+	setFlag(t, upstreamAttempts, 1)
+	w := newTestWorker(t, "a", "")
+	startTestDataPlane(t, w, map[string]int64{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}, func(entities []string) int {
+		if slices.Contains(entities, "e") {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	var mu sync.Mutex
+	var pushed [][]string
+	startTestMetricSink(t, w, func(submission types.MetricSinkSubmission) (types.MetricSinkSubmitResponse, int) {
+		var entities []string
+		for _, v := range submission.Values {
+			entities = append(entities, v.Entity)
+		}
+		slices.Sort(entities)
+		mu.Lock()
+		pushed = append(pushed, entities)
+		mu.Unlock()
+		if slices.Contains(entities, "c") {
+			return types.MetricSinkSubmitResponse{}, http.StatusBadRequest
+		}
+		return types.MetricSinkSubmitResponse{Accepted: len(entities)}, http.StatusOK
+	})
+
+	merged, err := w.getAndPushEntities(context.Background(), 1, ringState{}, "a", "b", "c", "d", "e")
+	if err == nil || !strings.HasPrefix(err.Error(), "2 of 3 batches failed") {
+		t.Fatalf("expected a fetch and a push failure, was: %v", err)
+	}
+	if len(merged.Entities) != 4 || merged.Entities["d"] != 4 {
+		t.Fatalf("expected the data of every fetched batch, was: %v", merged.Entities)
+	}
+	slices.SortFunc(pushed, slices.Compare)
+	if len(pushed) != 2 || !slices.Equal(pushed[0], []string{"a", "b"}) || !slices.Equal(pushed[1], []string{"c", "d"}) {
+		t.Fatalf("expected the fetched batches to be pushed despite the failures, was: %v", pushed)
+	}
+	if value := metricValue(t, w, `gossip_fetches_total{result="failure"}`); value != "1" {
+		t.Fatalf("expected 1 failed fetch, was: %q", value)
+	}
+	if value := metricValue(t, w, `gossip_pushes_total{result="failure"}`); value != "1" {
+		t.Fatalf("expected 1 failed push, was: %q", value)
+	}
+	if value := metricValue(t, w, `gossip_pushes_total{result="success"}`); value != "1" {
+		t.Fatalf("expected 1 successful push, was: %q", value)
+	}
+}
+
+func Test_getAndPushEntities_cancelled(t *testing.T) {
+	setFlag(t, batchSize, 1)
+	setFlag(t, fetchParallelism, 1)
+	setFlag(t, metricSinkURL, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := newTestWorker(t, "a", "")
+	var requests int
+	startTestDataPlane(t, w, map[string]int64{"a": 1, "b": 2, "c": 3}, func(entities []string) int {
+		// the single fetcher is busy with this batch until the tick is
+		// cancelled, so the remaining batches are never handed out.
+		requests++
+		cancel()
+		return 0
+	})
+
+	_, err := w.getAndPushEntities(ctx, 1, ringState{}, "a", "b", "c")
+	if err == nil || !strings.Contains(err.Error(), "2 batches skipped") || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the remaining batches to be skipped, was: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single fetch, was: %d", requests)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"gossip/pkg/types"
+	"hash/fnv"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,6 +22,7 @@ var symbolsData []byte
 
 var (
 	entities map[string]*Entity
+	infos    map[string]types.EntityInfo
 )
 
 func bindAddr() string {
@@ -30,17 +35,21 @@ func bindAddr() string {
 func main() {
 	symbols := getSymbols()
 	entities = make(map[string]*Entity, len(symbols))
+	infos = make(map[string]types.EntityInfo, len(symbols))
 	for _, symbol := range symbols {
-		entities[symbol] = &Entity{LastSeen: time.Now()}
+		entities[symbol.Symbol] = &Entity{LastSeen: time.Now()}
+		infos[symbol.Symbol] = symbol
 	}
+	list, etag := getList()
 	http.Handle("/", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		response := make([]string, 0, len(entities))
-		for key := range entities {
-			response = append(response, key)
+		rw.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
 		}
 		rw.Header().Set("Content-Type", "application/json")
 		rw.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(rw).Encode(response)
+		_, _ = rw.Write(list)
 	}))
 	http.Handle("/data", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		var requestSymbols []string
@@ -63,9 +72,37 @@ func main() {
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(response)
 	}))
+	http.Handle("/meta", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		response := make(map[string]types.EntityInfo)
+		if rawRequestSymbols := req.URL.Query().Get("s"); rawRequestSymbols != "" {
+			for _, requestSymbol := range strings.Split(rawRequestSymbols, ",") {
+				if info, ok := infos[requestSymbol]; ok {
+					response[requestSymbol] = info
+				}
+			}
+		} else {
+			response = infos
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(response)
+	}))
 	_ = http.ListenAndServe(bindAddr(), nil)
 }
 
+// getList returns the encoded entity list, sorted, and its etag.
+func getList() ([]byte, string) {
+	response := make([]string, 0, len(entities))
+	for key := range entities {
+		response = append(response, key)
+	}
+	slices.Sort(response)
+	data, _ := json.Marshal(response)
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return append(data, '\n'), fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
 type Entity struct {
 	LastSeen time.Time
 }
@@ -77,14 +114,30 @@ func (e *Entity) Increment() time.Duration {
 	return elapsed
 }
 
-func getSymbols() (symbols []string) {
+// getSymbols parses the symbol directory, with the columns:
+//
+//	Symbol|Security Name|Market Category|Test Issue|Financial Status|Round Lot Size|ETF|NextShares
+func getSymbols() (symbols []types.EntityInfo) {
 	r := bufio.NewReader(bytes.NewReader(symbolsData))
 	rs := bufio.NewScanner(r)
-	var line, symbol string
+	var line string
 	for rs.Scan() {
 		line = rs.Text()
-		symbol, _, _ = strings.Cut(line, "|")
-		symbols = append(symbols, symbol)
+		fields := strings.Split(line, "|")
+		for len(fields) < 8 {
+			fields = append(fields, "")
+		}
+		roundLot, _ := strconv.Atoi(fields[5])
+		symbols = append(symbols, types.EntityInfo{
+			Symbol:          fields[0],
+			Name:            fields[1],
+			Market:          fields[2],
+			TestIssue:       fields[3] == "Y",
+			FinancialStatus: fields[4],
+			RoundLot:        roundLot,
+			ETF:             fields[6] == "Y",
+			NextShares:      fields[7] == "Y",
+		})
 	}
 	return
 }
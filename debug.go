@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"gossip/pkg/nodemeta"
+	"gossip/pkg/sink"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// registerHealthHandlers adds the kubernetes liveness and readiness endpoints.
+func (w *worker) registerHealthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, req *http.Request) {
+		if err := w.healthy(); err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(rw, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(rw http.ResponseWriter, req *http.Request) {
+		if err := w.ready(); err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(rw, "ok")
+	})
+}
+
+// healthy returns an error if the run loop has stopped ticking, which
+// is judged like peers judge its heartbeats.
+func (w *worker) healthy() error {
+	last := w.lastTick.Load()
+	if last == 0 {
+		return nil
+	}
+	if since := time.Since(time.Unix(0, last)); since > w.heartbeatTimeout() {
+		return fmt.Errorf("run loop has not ticked in %v", since.Round(time.Second))
+	}
+	return nil
+}
+
+// ready returns an error unless the node has joined the cluster, is active,
+// and the ring including this node has been unchanged for the settle period.
+func (w *worker) ready() error {
+	if !w.joined.Load() {
+		return fmt.Errorf("not joined")
+	}
+	if state := w.localMeta().State; state != nodemeta.StateActive {
+		return fmt.Errorf("node is %s", state)
+	}
+	ring := w.currentRing()
+	if ring.Ring == nil {
+		return fmt.Errorf("ring not built")
+	}
+	if !slices.Contains(ring.Ring.Buckets(), w.hostname) {
+		return fmt.Errorf("node is not in the ring")
+	}
+	if since := time.Since(ring.ChangedAt); since < *settle {
+		return fmt.Errorf("ring changed %v ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+// memberStatus is the debug representation of a member.
+type memberStatus struct {
+	Name    string        `json:"name"`
+	Address string        `json:"address"`
+	Meta    nodemeta.Meta `json:"meta"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// registerDebugHandlers adds endpoints for inspecting membership and ownership.
+func (w *worker) registerDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/members", func(rw http.ResponseWriter, req *http.Request) {
+		var output []memberStatus
+		for _, m := range w.list.Members() {
+			status := memberStatus{
+				Name:    m.Name,
+				Address: m.Address(),
+			}
+			meta, err := nodemeta.Decode(m.Meta)
+			if err != nil {
+				status.Error = err.Error()
+			}
+			status.Meta = meta
+			output = append(output, status)
+		}
+		slices.SortFunc(output, func(i, j memberStatus) int {
+			return strings.Compare(i.Name, j.Name)
+		})
+		writeJSON(rw, http.StatusOK, output)
+	})
+	mux.HandleFunc("GET /debug/ring", func(rw http.ResponseWriter, req *http.Request) {
+		ring := w.currentRing()
+		if ring.Ring == nil {
+			http.Error(rw, "ring not built", http.StatusServiceUnavailable)
+			return
+		}
+		data, err := ring.Ring.MarshalJSON()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(data)
+	})
+	mux.HandleFunc("GET /debug/assignments", func(rw http.ResponseWriter, req *http.Request) {
+		ring := w.currentRing()
+		if ring.Ring == nil {
+			http.Error(rw, "ring not built", http.StatusServiceUnavailable)
+			return
+		}
+		var entities []string
+		for _, value := range req.URL.Query()["entity"] {
+			entities = append(entities, strings.Split(value, ",")...)
+		}
+		if len(entities) == 0 {
+			http.Error(rw, "missing `entity` query parameter", http.StatusBadRequest)
+			return
+		}
+		assignments := make(map[string]string, len(entities))
+		for _, e := range entities {
+			assignments[e] = ring.Ring.Assignment(e)
+		}
+		writeJSON(rw, http.StatusOK, map[string]any{
+			"fingerprint": ring.Fingerprint,
+			"assignments": assignments,
+		})
+	})
+	mux.HandleFunc("GET /debug/polls", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]any{
+			"tick":    w.scheduler.IntervalOrDefault().String(),
+			"entries": w.polls.Entries(),
+		})
+	})
+	mux.HandleFunc("GET /debug/sinks", func(rw http.ResponseWriter, req *http.Request) {
+		var stats []sink.Stats
+		if w.fanout != nil {
+			stats = w.fanout.Stats()
+		}
+		writeJSON(rw, http.StatusOK, stats)
+	})
+	mux.HandleFunc("GET /debug/owned", func(rw http.ResponseWriter, req *http.Request) {
+		ring := w.currentRing()
+		writeJSON(rw, http.StatusOK, map[string]any{
+			"fingerprint": ring.Fingerprint,
+			"count":       len(ring.Owned),
+			"entities":    ring.Owned,
+		})
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"gossip/pkg/consistenthash"
+	"gossip/pkg/nodemeta"
+	"gossip/pkg/scheduler"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// probe requests a health endpoint, returning its status code and body.
+func probe(t *testing.T, w *worker, path string) (int, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	w.registerHealthHandlers(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func Test_healthz(t *testing.T) {
+	w := newTestWorker(t, "a", "")
+	w.scheduler = &scheduler.Scheduler{Interval: time.Second, Jitter: 500 * time.Millisecond}
+
+	if code, body := probe(t, w, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected a node that hasn't ticked yet to be healthy, was: %d %s", code, body)
+	}
+	// a tick waiting on a slow upstream for several scheduler intervals is within its limit.
+	w.lastTick.Store(time.Now().Add(-5 * time.Second).UnixNano())
+	if code, body := probe(t, w, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected a tick running longer than the scheduler interval to be healthy, was: %d %s", code, body)
+	}
+	w.lastTick.Store(time.Now().Add(-w.maxTickDuration() - 2*time.Second).UnixNano())
+	if code, body := probe(t, w, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "has not ticked") {
+		t.Fatalf("expected a tick running past the max tick duration to be unhealthy, was: %d %s", code, body)
+	}
+}
+
+func Test_readyz(t *testing.T) {
+	w := newTestWorker(t, "a", "")
+	ring := func(buckets ...string) *consistenthash.ConsistentHash {
+		ch := consistenthash.New()
+		for _, b := range buckets {
+			ch.AddWeightedBucket(b, 1)
+		}
+		return ch
+	}
+	settled := time.Now().Add(-*settle - time.Second)
+
+	testCases := []struct {
+		name   string
+		joined bool
+		state  nodemeta.State
+		ring   ringState
+		err    string
+	}{
+		{
+			name: "not joined",
+			err:  "not joined",
+		},
+		{
+			name:   "draining",
+			joined: true,
+			state:  nodemeta.StateDraining,
+			ring:   ringState{Ring: ring("a", "b"), ChangedAt: settled},
+			err:    "node is draining",
+		},
+		{
+			name:   "no ring",
+			joined: true,
+			state:  nodemeta.StateActive,
+			err:    "ring not built",
+		},
+		{
+			name:   "not in ring",
+			joined: true,
+			state:  nodemeta.StateActive,
+			ring:   ringState{Ring: ring("b"), ChangedAt: settled},
+			err:    "not in the ring",
+		},
+		{
+			name:   "settling",
+			joined: true,
+			state:  nodemeta.StateActive,
+			ring:   ringState{Ring: ring("a", "b"), ChangedAt: time.Now()},
+			err:    "ring changed",
+		},
+		{
+			name:   "settled",
+			joined: true,
+			state:  nodemeta.StateActive,
+			ring:   ringState{Ring: ring("a", "b"), ChangedAt: settled},
+		},
+		{
+			name:   "takeover",
+			joined: true,
+			state:  nodemeta.StateActive,
+			ring:   ringState{Ring: ring("a", "b"), ChangedAt: settled, EpochView: time.Now().UnixMilli(), TakenOver: []string{"b"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w.joined.Store(tc.joined)
+			w.meta.State = tc.state
+			w.ring = tc.ring
+			code, body := probe(t, w, "/readyz")
+			if tc.err == "" && code != http.StatusOK {
+				t.Fatalf("expected ready, was: %d %s", code, body)
+			}
+			if tc.err != "" && (code != http.StatusServiceUnavailable || !strings.Contains(body, tc.err)) {
+				t.Fatalf("expected not ready with %q, was: %d %s", tc.err, code, body)
+			}
+		})
+	}
+}
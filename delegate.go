@@ -0,0 +1,99 @@
+package main
+
+import (
+	"gossip/pkg/nodemeta"
+	"log/slog"
+	"time"
+)
+
+// NodeMeta implements memberlist.Delegate and returns the encoded node metadata.
+func (w *worker) NodeMeta(limit int) []byte {
+	data, err := w.localMeta().Encode()
+	if err != nil {
+		slog.Error("failed to encode node metadata", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return nil
+	}
+	if len(data) > limit {
+		slog.Error("node metadata exceeds limit", slog.String("hostname", w.hostname), slog.Int("size", len(data)), slog.Int("limit", limit))
+		return nil
+	}
+	return data
+}
+
+// Message types prefix every user message the worker sends,
+// such that `NotifyMsg` can tell them apart.
+const (
+	messageUserEvent byte = iota + 1
+	messageQuery
+	messageQueryResponse
+	messageEntityList
+	messageHandoff
+	messageHandoffAck
+	messageHeartbeat
+)
+
+// NotifyMsg implements memberlist.Delegate and dispatches user messages by type.
+func (w *worker) NotifyMsg(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	switch msg[0] {
+	case messageUserEvent:
+		w.receiveEvent(msg[1:])
+	case messageQuery:
+		w.receiveQuery(msg[1:])
+	case messageQueryResponse:
+		w.receiveQueryResponse(msg[1:])
+	case messageEntityList:
+		w.receiveEntityList(msg[1:])
+	case messageHandoff:
+		w.receiveHandoff(msg[1:])
+	case messageHandoffAck:
+		w.receiveHandoffAck(msg[1:])
+	case messageHeartbeat:
+		w.receiveHeartbeat(msg[1:])
+	default:
+		slog.Warn("unknown message type", slog.String("hostname", w.hostname), slog.Int("type", int(msg[0])))
+	}
+}
+
+// GetBroadcasts implements memberlist.Delegate and returns queued
+// query, heartbeat and user event broadcasts, in that order.
+func (w *worker) GetBroadcasts(overhead, limit int) [][]byte {
+	msgs := w.queries.GetBroadcasts(overhead, limit)
+	for _, msg := range msgs {
+		limit -= overhead + len(msg)
+	}
+	heartbeats := w.heartbeats.GetBroadcasts(overhead, limit)
+	for _, msg := range heartbeats {
+		limit -= overhead + len(msg)
+	}
+	msgs = append(msgs, heartbeats...)
+	return append(msgs, w.events.GetBroadcasts(overhead, limit)...)
+}
+
+// localMeta returns a copy of the local node metadata.
+func (w *worker) localMeta() nodemeta.Meta {
+	w.metaMu.Lock()
+	defer w.metaMu.Unlock()
+	return w.meta
+}
+
+// setState sets the advertised lifecycle state, re-broadcasting
+// the node metadata to the cluster if it changed.
+func (w *worker) setState(state nodemeta.State) {
+	w.metaMu.Lock()
+	changed := w.meta.State != state
+	w.meta.State = state
+	if changed {
+		w.incarnation++
+	}
+	w.metaMu.Unlock()
+	if !changed || w.list == nil {
+		return
+	}
+	slog.Info("node state changed", slog.String("hostname", w.hostname), slog.String("state", state.String()))
+	if err := w.list.UpdateNode(10 * time.Second); err != nil {
+		slog.Error("failed to broadcast node metadata", slog.String("hostname", w.hostname), slog.Any("err", err))
+	}
+}
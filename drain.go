@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gossip/pkg/nodemeta"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// errInterrupted is returned by `tryJoin` if the node is asked to stop before it joins.
+var errInterrupted = errors.New("interrupted before join")
+
+var terminationGracePeriod = flag.Duration("termination-grace-period", 60*time.Second, "How long the node is given to stop after SIGTERM before it is killed; draining, finishing and leaving must fit within it")
+
+// leaveTimeout is how long a node waits for its leave to propagate before it shuts down.
+const leaveTimeout = 10 * time.Second
+
+// drainStatus is the response to a drain request.
+type drainStatus struct {
+	State   nodemeta.State `json:"state"`
+	Timeout string         `json:"timeout"`
+}
+
+// registerDrainHandlers adds the drain control endpoint.
+func (w *worker) registerDrainHandlers(control *http.ServeMux) {
+	control.HandleFunc("POST /admin/drain", func(rw http.ResponseWriter, req *http.Request) {
+		w.requestDrain()
+		writeJSON(rw, http.StatusAccepted, drainStatus{
+			State:   nodemeta.StateDraining,
+			Timeout: w.drainTimeoutOrDefault().String(),
+		})
+	})
+}
+
+// requestDrain asks the run loop to start draining; repeated requests are ignored.
+func (w *worker) requestDrain() {
+	select {
+	case w.drain <- struct{}{}:
+	default:
+	}
+}
+
+// drainTimeoutOrDefault returns the drain timeout, or by default what's left of the
+// termination grace period once the node finishes, but at most two intervals.
+func (w *worker) drainTimeoutOrDefault() time.Duration {
+	if *drainTimeout > 0 {
+		return *drainTimeout
+	}
+	return min(2*(*interval), *terminationGracePeriod-w.finishTimeout())
+}
+
+// finishTimeout returns the longest a node may take to leave once the drain
+// timeout elapses; the tick in flight, flushing the spool and handing off
+// entity state in `finishDrain`, and leaving the cluster.
+func (w *worker) finishTimeout() time.Duration {
+	return 2*w.maxTickDuration() + *handoffTimeout + leaveTimeout
+}
+
+// checkDrainTimeout returns an error if the drain timeout doesn't give every
+// peer a tick to take over the node's entities, or if draining and finishing
+// don't fit in the termination grace period, after which the node is killed.
+func (w *worker) checkDrainTimeout() error {
+	timeout := w.drainTimeoutOrDefault()
+	if tick := w.scheduler.IntervalOrDefault() + w.scheduler.Jitter; timeout < tick {
+		return fmt.Errorf("drain timeout %s is shorter than a tick of %s", timeout, tick)
+	}
+	if finish := w.finishTimeout(); timeout+finish > *terminationGracePeriod {
+		return fmt.Errorf("drain timeout %s and finishing within %s exceed the termination grace period of %s", timeout, finish, *terminationGracePeriod)
+	}
+	return nil
+}
+
+// startDrain advertises the draining state so peers remove this node from
+// their rings, and returns a channel that fires once the drain timeout elapses.
+//
+// The node keeps itself in its own ring and keeps ticking while draining, so its
+// entities are still pushed until peers take them over; the metric-sink fences
+// its writes once a peer pushes with the newer epoch.
+func (w *worker) startDrain() <-chan time.Time {
+	timeout := w.drainTimeoutOrDefault()
+	slog.Info("draining", slog.String("hostname", w.hostname), slog.Duration("timeout", timeout))
+	w.setState(nodemeta.StateDraining)
+	return time.After(timeout)
+}
+
+// finishDrain flushes spooled submissions and hands off entity state
+// before the node leaves the cluster.
+//
+// Submissions that can't be flushed stay in the spool, and are replayed
+// on the next start if the spool directory is persistent.
+func (w *worker) finishDrain() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.maxTickDuration())
+	defer cancel()
+	if *aggregateWindow > 0 {
+		if err := w.flushAggregates(ctx, w.currentRing(), time.Now(), true); err != nil {
+			slog.Error("failed to flush aggregates", slog.String("hostname", w.hostname), slog.Any("err", err))
+		}
+	}
+	w.replaySpool(ctx, 0)
+	w.handOffDrained()
+	if remaining := w.spoolStats().Records; remaining > 0 {
+		slog.Warn("leaving with spooled submissions", slog.String("hostname", w.hostname), slog.Int("remaining", remaining))
+	}
+	slog.Info("drain complete", slog.String("hostname", w.hostname))
+}
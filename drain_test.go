@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"gossip/pkg/nodemeta"
+	"gossip/pkg/scheduler"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_checkDrainTimeout(t *testing.T) {
+	w := newTestWorker(t, "a", "")
+	w.scheduler = &scheduler.Scheduler{Interval: 10 * time.Second, Jitter: 500 * time.Millisecond}
+
+	if err := w.checkDrainTimeout(); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	// 60s less a tick in flight (15s), the flush (15s), the handoff (5s) and leaving (10s).
+	if timeout := w.drainTimeoutOrDefault(); timeout != 15*time.Second {
+		t.Fatalf("expected the drain timeout to be what's left of the termination grace period, was: %s", timeout)
+	}
+
+	setFlag(t, terminationGracePeriod, 50*time.Second)
+	if err := w.checkDrainTimeout(); err == nil || !strings.Contains(err.Error(), "shorter than a tick") {
+		t.Fatalf("expected a termination grace period without time for peers to take over to be rejected, was: %v", err)
+	}
+
+	setFlag(t, terminationGracePeriod, 2*time.Minute)
+	if timeout := w.drainTimeoutOrDefault(); timeout != 20*time.Second {
+		t.Fatalf("expected the drain timeout to be at most two intervals, was: %s", timeout)
+	}
+	setFlag(t, drainTimeout, 90*time.Second)
+	if err := w.checkDrainTimeout(); err == nil || !strings.Contains(err.Error(), "exceed the termination grace period") {
+		t.Fatalf("expected a drain timeout that doesn't fit in the termination grace period to be rejected, was: %v", err)
+	}
+}
+
+func Test_runLoop_drain(t *testing.T) {
+	setFlag(t, drainTimeout, 500*time.Millisecond)
+	setFlag(t, handoffTimeout, 100*time.Millisecond)
+	setFlag(t, metricSinkURL, "")
+	w := newTestWorker(t, "a", "")
+	w.scheduler = &scheduler.Scheduler{Interval: 100 * time.Millisecond}
+	shutdown := make(chan os.Signal, 1)
+	w.shutdown = shutdown
+	peer := newTestWorker(t, "b", "")
+	startTestMemberlist(t, w)
+	startTestMemberlist(t, peer)
+	if _, err := peer.list.Join([]string{w.list.LocalNode().Address()}); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+
+	// the first tick hangs on the entity list until released.
+	listing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			select {
+			case listing <- struct{}{}:
+				<-release
+			default:
+			}
+		}
+		_ = json.NewEncoder(rw).Encode([]string{})
+	}))
+	defer server.Close()
+	w.sources = []*source{{URL: server.URL, Client: w.newUpstreamClient("data-plane")}}
+	w.setState(nodemeta.StateActive)
+	w.joined.Store(true)
+
+	done := make(chan error, 1)
+	go func() { done <- w.runLoop() }()
+	select {
+	case <-listing:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the node to tick")
+	}
+	shutdown <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		t.Fatalf("expected the node to wait for the tick in flight, left with: %v", err)
+	case <-time.After(2 * (*drainTimeout)):
+	}
+	close(release)
+
+	deadline := time.Now().Add(*drainTimeout)
+	for w.localMeta().State != nodemeta.StateDraining {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the node to drain once the tick completed, was: %s", w.localMeta().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, body := probe(t, w, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "draining") {
+		t.Fatalf("expected a draining node not to be ready, was: %d %s", code, body)
+	}
+	if peer.list.NumMembers() != 2 {
+		t.Fatalf("expected the node not to leave before the drain timeout, members: %d", peer.list.NumMembers())
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+	case <-time.After(w.finishTimeout()):
+		t.Fatalf("expected the node to leave after the drain timeout")
+	}
+	for deadline := time.Now().Add(5 * time.Second); peer.list.NumMembers() != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the peer to see the node leave, members: %d", peer.list.NumMembers())
+		}
+	}
+}
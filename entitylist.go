@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"gossip/pkg/events"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var entityListStale = flag.Duration("entity-list-stale", 0, "How old a gossiped entity list may be before a node fetches the list itself; defaults to three intervals")
+
+// eventEntityList is the user event that carries a change to a shared entity list.
+const eventEntityList = "entity-list"
+
+// listFetcherKey is hashed onto the ring, suffixed with the source
+// name, to elect the node that fetches the source's entity list.
+const listFetcherKey = "entity-list/"
+
+// sharedList is a source's entity list as fetched by one node and shared
+// with the others, which then don't need to fetch it themselves.
+type sharedList struct {
+	Source string `json:"source"`
+	// Version is a hash of the entities, which diffs are based on.
+	Version  uint64   `json:"version"`
+	ETag     string   `json:"etag,omitempty"`
+	Entities []string `json:"entities"`
+	// FetchedAt is when the fetcher last fetched the list, changed or not.
+	FetchedAt time.Time `json:"fetchedAt"`
+	Fetcher   string    `json:"fetcher"`
+}
+
+// listDiff is a change to a shared list, small enough to broadcast as a user
+// event; a diff without changes only tells that the list is still current.
+type listDiff struct {
+	Source    string    `json:"source"`
+	Base      uint64    `json:"base"`
+	Version   uint64    `json:"version"`
+	ETag      string    `json:"etag,omitempty"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Fetcher   string    `json:"fetcher"`
+}
+
+// listVersion returns the version of a sorted entity list.
+func listVersion(entities []string) uint64 {
+	h := fnv.New64a()
+	for _, e := range entities {
+		_, _ = h.Write([]byte(e))
+		_, _ = h.Write([]byte{'\n'})
+	}
+	return h.Sum64()
+}
+
+// entityLists holds the latest entity list of every source.
+type entityLists struct {
+	mu    sync.Mutex
+	lists map[string]sharedList
+}
+
+// Get returns a source's list.
+func (el *entityLists) Get(source string) (sharedList, bool) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	l, ok := el.lists[source]
+	return l, ok
+}
+
+// All returns every source's list.
+func (el *entityLists) All() []sharedList {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	output := make([]sharedList, 0, len(el.lists))
+	for _, l := range el.lists {
+		output = append(output, l)
+	}
+	slices.SortFunc(output, func(i, j sharedList) int {
+		return strings.Compare(i.Source, j.Source)
+	})
+	return output
+}
+
+// Merge keeps a list if it was fetched more recently than the one held,
+// returning if it was kept.
+func (el *entityLists) Merge(l sharedList) bool {
+	if !slices.IsSorted(l.Entities) || listVersion(l.Entities) != l.Version {
+		return false
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if current, ok := el.lists[l.Source]; ok && !l.FetchedAt.After(current.FetchedAt) {
+		return false
+	}
+	if el.lists == nil {
+		el.lists = make(map[string]sharedList)
+	}
+	el.lists[l.Source] = l
+	return true
+}
+
+// Apply applies a diff to the list it is based on, returning false
+// if the list held is a different version, in which case the full
+// list arrives later by a reliable message or a push/pull.
+func (el *entityLists) Apply(d listDiff) bool {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	current, ok := el.lists[d.Source]
+	if !ok {
+		return false
+	}
+	if current.Version != d.Version {
+		if current.Version != d.Base {
+			return false
+		}
+		entities := slices.DeleteFunc(slices.Clone(current.Entities), func(e string) bool {
+			return slices.Contains(d.Removed, e)
+		})
+		entities = append(entities, d.Added...)
+		slices.Sort(entities)
+		entities = slices.Compact(entities)
+		if listVersion(entities) != d.Version {
+			return false
+		}
+		current.Entities = entities
+		current.Version = d.Version
+	}
+	if d.FetchedAt.After(current.FetchedAt) {
+		current.ETag = d.ETag
+		current.FetchedAt = d.FetchedAt
+		current.Fetcher = d.Fetcher
+	}
+	el.lists[d.Source] = current
+	return true
+}
+
+// listFetcher returns the node that fetches a source's entity list, which is
+// this node until the ring is built.
+func (w *worker) listFetcher(ring ringState, s *source) string {
+	if ring.Ring == nil {
+		return w.hostname
+	}
+	return ring.Ring.Assignment(listFetcherKey + s.Name)
+}
+
+// listStaleOrDefault returns how old a gossiped list may get, or a default of three intervals.
+func listStaleOrDefault() time.Duration {
+	if *entityListStale > 0 {
+		return *entityListStale
+	}
+	return 3 * (*interval)
+}
+
+// fetchEntityList fetches a source's entity list, conditionally on the
+// etag of the list held, and shares it with the cluster if asked to.
+func (w *worker) fetchEntityList(ctx context.Context, s *source, share bool) error {
+	previous, _ := w.lists.Get(s.Name)
+	entities, etag, notModified, err := w.getEntityList(ctx, s, previous.ETag)
+	if err != nil {
+		return err
+	}
+	next := sharedList{
+		Source:    s.Name,
+		Version:   previous.Version,
+		ETag:      etag,
+		Entities:  previous.Entities,
+		FetchedAt: time.Now(),
+		Fetcher:   w.hostname,
+	}
+	if !notModified {
+		slices.Sort(entities)
+		next.Entities = slices.Compact(entities)
+		next.Version = listVersion(next.Entities)
+	}
+	w.lists.Merge(next)
+	if share {
+		w.shareEntityList(previous, next)
+	}
+	return nil
+}
+
+// shareEntityList broadcasts the change from the previous list as a user
+// event if it is small enough, and otherwise sends the full list to every member.
+func (w *worker) shareEntityList(previous, next sharedList) {
+	d := listDiff{
+		Source:    next.Source,
+		Base:      previous.Version,
+		Version:   next.Version,
+		ETag:      next.ETag,
+		FetchedAt: next.FetchedAt,
+		Fetcher:   next.Fetcher,
+	}
+	if previous.Version != next.Version {
+		for _, e := range next.Entities {
+			if _, found := slices.BinarySearch(previous.Entities, e); !found {
+				d.Added = append(d.Added, e)
+			}
+		}
+		for _, e := range previous.Entities {
+			if _, found := slices.BinarySearch(next.Entities, e); !found {
+				d.Removed = append(d.Removed, e)
+			}
+		}
+	}
+	if previous.Version != 0 {
+		payload, err := json.Marshal(d)
+		if err == nil && len(payload) <= events.MaxPayloadSize {
+			if _, err := w.events.Fire(eventEntityList, payload); err == nil {
+				w.metrics.entityListShares.Inc("diff")
+				return
+			}
+		}
+	}
+	w.metrics.entityListShares.Inc("full")
+	go w.sendEntityList(next)
+}
+
+// sendEntityList sends a full list to every other member over tcp.
+func (w *worker) sendEntityList(l sharedList) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		slog.Error("failed to encode entity list", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	msg := append([]byte{messageEntityList}, data...)
+	for _, m := range w.list.Members() {
+		if m.Name == w.hostname {
+			continue
+		}
+		if err := w.list.SendReliable(m, msg); err != nil {
+			slog.Warn("failed to send entity list", slog.String("hostname", w.hostname), slog.String("member-name", m.Name), slog.Any("err", err))
+		}
+	}
+}
+
+// receiveEntityList keeps a full list sent by the fetcher.
+func (w *worker) receiveEntityList(msg []byte) {
+	var l sharedList
+	if err := json.Unmarshal(msg, &l); err != nil {
+		slog.Error("failed to decode entity list", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	if w.lists.Merge(l) {
+		w.metrics.entityListReceived.Inc("full")
+	}
+}
+
+// handleEntityListEvent applies a diff broadcast by another node's fetcher.
+func (w *worker) handleEntityListEvent(e events.Event) {
+	if e.Origin == w.hostname {
+		return
+	}
+	var d listDiff
+	if err := json.Unmarshal(e.Payload, &d); err != nil {
+		slog.Error("failed to decode entity list diff", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	if w.lists.Apply(d) {
+		w.metrics.entityListReceived.Inc("diff")
+	} else {
+		w.metrics.entityListReceived.Inc("missed")
+	}
+}
+
+// entityListStatus is the debug representation of a source's list.
+type entityListStatus struct {
+	Source    string    `json:"source"`
+	Version   uint64    `json:"version"`
+	ETag      string    `json:"etag,omitempty"`
+	Count     int       `json:"count"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Fetcher   string    `json:"fetcher"`
+	// Elected is the node this node expects to fetch the list.
+	Elected string `json:"elected"`
+	Stale   bool   `json:"stale"`
+}
+
+// registerEntityListHandlers adds the entity list debug endpoint.
+func (w *worker) registerEntityListHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/entity-lists", func(rw http.ResponseWriter, req *http.Request) {
+		ring := w.currentRing()
+		var output []entityListStatus
+		for _, s := range w.sources {
+			l, _ := w.lists.Get(s.Name)
+			output = append(output, entityListStatus{
+				Source:    s.Name,
+				Version:   l.Version,
+				ETag:      l.ETag,
+				Count:     len(l.Entities),
+				FetchedAt: l.FetchedAt,
+				Fetcher:   l.Fetcher,
+				Elected:   w.listFetcher(ring, s),
+				Stale:     time.Since(l.FetchedAt) >= listStaleOrDefault(),
+			})
+		}
+		writeJSON(rw, http.StatusOK, output)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"gossip/pkg/events"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testList returns a shared list of entities, sorted and versioned.
+func testList(fetchedAt time.Time, entities ...string) sharedList {
+	slices.Sort(entities)
+	return sharedList{Source: "s", Version: listVersion(entities), Entities: entities, FetchedAt: fetchedAt, Fetcher: "a"}
+}
+
+func Test_entityLists_Merge(t *testing.T) {
+	var el entityLists
+	now := time.Unix(1000, 0)
+	if !el.Merge(testList(now, "a", "b")) {
+		t.Fatalf("expected the first list to be kept")
+	}
+	if el.Merge(testList(now.Add(-time.Second), "a", "b", "c")) {
+		t.Fatalf("expected a list fetched before the one held not to be merged")
+	}
+	if el.Merge(testList(now, "a", "b", "c")) {
+		t.Fatalf("expected a list fetched at the same time as the one held not to be merged")
+	}
+
+	unsorted := testList(now.Add(time.Second), "a", "b")
+	unsorted.Entities = []string{"b", "a"}
+	if el.Merge(unsorted) {
+		t.Fatalf("expected an unsorted list to be rejected")
+	}
+	mismatched := testList(now.Add(time.Second), "a", "b")
+	mismatched.Version++
+	if el.Merge(mismatched) {
+		t.Fatalf("expected a list that doesn't match its version to be rejected")
+	}
+	if l, _ := el.Get("s"); !l.FetchedAt.Equal(now) || len(l.Entities) != 2 {
+		t.Fatalf("expected the list held to be unchanged, was: %+v", l)
+	}
+
+	if !el.Merge(testList(now.Add(time.Second), "c")) {
+		t.Fatalf("expected a newer list to be kept")
+	}
+}
+
+func Test_entityLists_Apply(t *testing.T) {
+	now := time.Unix(1000, 0)
+	previous := testList(now, "a", "b", "c")
+	next := testList(now.Add(time.Second), "a", "c", "d")
+	diff := listDiff{
+		Source:    "s",
+		Base:      previous.Version,
+		Version:   next.Version,
+		ETag:      "v2",
+		Added:     []string{"d"},
+		Removed:   []string{"b"},
+		FetchedAt: next.FetchedAt,
+		Fetcher:   "a",
+	}
+
+	var el entityLists
+	if el.Apply(diff) {
+		t.Fatalf("expected a diff for a list that isn't held to be missed")
+	}
+
+	el.Merge(testList(now, "x"))
+	if el.Apply(diff) {
+		t.Fatalf("expected a diff on a different base to be missed")
+	}
+	if l, _ := el.Get("s"); !slices.Equal(l.Entities, []string{"x"}) {
+		t.Fatalf("expected the list held to be unchanged, was: %v", l.Entities)
+	}
+
+	el = entityLists{}
+	el.Merge(previous)
+	if !el.Apply(diff) {
+		t.Fatalf("expected the diff to apply to its base")
+	}
+	l, _ := el.Get("s")
+	if l.Version != next.Version || !slices.Equal(l.Entities, next.Entities) || l.ETag != "v2" || !l.FetchedAt.Equal(next.FetchedAt) {
+		t.Fatalf("expected the diff to reproduce the next list, was: %+v", l)
+	}
+
+	wrong := diff
+	wrong.Version++
+	el = entityLists{}
+	el.Merge(previous)
+	if el.Apply(wrong) {
+		t.Fatalf("expected a diff that doesn't reproduce its version to be missed")
+	}
+
+	unchanged := listDiff{Source: "s", Base: next.Version, Version: next.Version, ETag: "v3", FetchedAt: now.Add(2 * time.Second), Fetcher: "b"}
+	el = entityLists{}
+	el.Merge(next)
+	if !el.Apply(unchanged) {
+		t.Fatalf("expected a diff without changes to apply")
+	}
+	l, _ = el.Get("s")
+	if !slices.Equal(l.Entities, next.Entities) || l.Version != next.Version || !l.FetchedAt.Equal(unchanged.FetchedAt) || l.Fetcher != "b" || l.ETag != "v3" {
+		t.Fatalf("expected a diff without changes to only advance the fetch, was: %+v", l)
+	}
+	older := unchanged
+	older.FetchedAt = now
+	older.ETag = "v1"
+	if !el.Apply(older) {
+		t.Fatalf("expected an older diff on the same version to apply")
+	}
+	if l, _ := el.Get("s"); !l.FetchedAt.Equal(unchanged.FetchedAt) || l.ETag != "v3" {
+		t.Fatalf("expected an older diff not to move the fetch back, was: %+v", l)
+	}
+}
+
+func Test_shareEntityList(t *testing.T) {
+	fetcher := newTestWorker(t, "a", "")
+	startTestMemberlist(t, fetcher)
+	peer := newTestWorker(t, "b", "")
+	now := time.Unix(1000, 0)
+
+	// the first list is always sent in full, as peers have nothing to diff against.
+	previous := testList(now, "a", "b")
+	fetcher.lists.Merge(previous)
+	fetcher.shareEntityList(sharedList{}, previous)
+	if value := metricValue(t, fetcher, `gossip_entity_list_shares_total{kind="full"}`); value != "1" {
+		t.Fatalf("expected the first list to be sent in full, was: %q", value)
+	}
+	peer.lists.Merge(previous)
+
+	next := testList(now.Add(time.Second), "a", "c")
+	fetcher.lists.Merge(next)
+	fetcher.shareEntityList(previous, next)
+	if value := metricValue(t, fetcher, `gossip_entity_list_shares_total{kind="diff"}`); value != "1" {
+		t.Fatalf("expected a small change to be broadcast as a diff, was: %q", value)
+	}
+	if delivered := deliver(t, fetcher, peer); delivered != 1 {
+		t.Fatalf("expected one diff to be broadcast, was: %d", delivered)
+	}
+	if l, _ := peer.lists.Get("s"); l.Version != next.Version || !slices.Equal(l.Entities, next.Entities) {
+		t.Fatalf("expected the peer to apply the diff, was: %+v", l)
+	}
+	if value := metricValue(t, peer, `gossip_entity_list_received_total{kind="diff"}`); value != "1" {
+		t.Fatalf("expected the peer to count the diff, was: %q", value)
+	}
+
+	// a change too large for an event is sent in full.
+	var entities []string
+	for index := range events.MaxPayloadSize / 8 {
+		entities = append(entities, fmt.Sprintf("entity-%d", index))
+	}
+	large := testList(now.Add(2*time.Second), entities...)
+	fetcher.shareEntityList(next, large)
+	if value := metricValue(t, fetcher, `gossip_entity_list_shares_total{kind="full"}`); value != "2" {
+		t.Fatalf("expected a change larger than an event to be sent in full, was: %q", value)
+	}
+	if delivered := deliver(t, fetcher, peer); delivered != 0 {
+		t.Fatalf("expected no diff to be broadcast, was: %d", delivered)
+	}
+}
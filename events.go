@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gossip/pkg/events"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+)
+
+// User events the worker handles.
+const (
+	// eventRefreshEntities refreshes the entity lists on the next tick.
+	eventRefreshEntities = "refresh-entities"
+	// eventPausePush stops fetching and pushing, for a duration given as
+	// the payload, e.g. `10m`, or until resumed if there is no payload.
+	eventPausePush = "pause-push"
+	// eventResumePush resumes fetching and pushing.
+	eventResumePush = "resume-push"
+)
+
+// newEventBus returns the user event bus with the worker's handlers registered.
+func (w *worker) newEventBus() *events.Bus {
+	bus := events.New(w.hostname, events.Options{
+		NumNodes: w.numPeers,
+		Prefix:   []byte{messageUserEvent},
+	})
+	bus.Handle(eventRefreshEntities, func(events.Event) {
+		w.refreshRequested.Store(true)
+	})
+	bus.Handle(eventPausePush, func(e events.Event) {
+		until := int64(math.MaxInt64)
+		if len(e.Payload) > 0 {
+			d, err := time.ParseDuration(string(e.Payload))
+			if err != nil {
+				slog.Error("invalid pause duration", slog.String("hostname", w.hostname), slog.String("origin", e.Origin), slog.Any("err", err))
+				return
+			}
+			until = time.Now().Add(d).UnixNano()
+		}
+		w.pausedUntil.Store(until)
+	})
+	bus.Handle(eventResumePush, func(events.Event) {
+		w.pausedUntil.Store(0)
+	})
+	bus.Handle(eventEntityList, w.handleEntityListEvent)
+	return bus
+}
+
+// receiveEvent handles a user event broadcast by another node.
+func (w *worker) receiveEvent(msg []byte) {
+	e, err := w.events.Receive(msg)
+	switch {
+	case errors.Is(err, events.ErrDuplicate):
+		w.metrics.userEvents.Inc("duplicate")
+	case errors.Is(err, events.ErrStale):
+		w.metrics.userEvents.Inc("stale")
+	case err != nil:
+		w.metrics.userEvents.Inc("invalid")
+		slog.Error("failed to receive event", slog.String("hostname", w.hostname), slog.Any("err", err))
+	default:
+		w.observeEvent(e)
+	}
+}
+
+// observeEvent logs and counts a new event.
+func (w *worker) observeEvent(e events.Event) {
+	result := "handled"
+	if !w.events.Handled(e.Name) {
+		result = "unhandled"
+	}
+	w.metrics.userEvents.Inc(result)
+	slog.Info("user event", slog.String("hostname", w.hostname), slog.String("name", e.Name), slog.String("origin", e.Origin), slog.Uint64("ltime", e.LTime), slog.String("result", result))
+}
+
+// pushPaused returns if fetching and pushing is paused by a user event.
+func (w *worker) pushPaused(now time.Time) bool {
+	return w.pausedUntil.Load() > now.UnixNano()
+}
+
+// eventsStatus is the response to listing events.
+type eventsStatus struct {
+	LTime  uint64         `json:"ltime"`
+	Queued int            `json:"queued"`
+	Paused bool           `json:"paused"`
+	Recent []events.Event `json:"recent"`
+}
+
+// registerEventHandlers adds the user event admin endpoints, with
+// firing events on the control mux.
+func (w *worker) registerEventHandlers(mux, control *http.ServeMux) {
+	mux.HandleFunc("GET /admin/events", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, eventsStatus{
+			LTime:  w.events.Time(),
+			Queued: w.events.Queued(),
+			Paused: w.pushPaused(time.Now()),
+			Recent: w.events.Recent(),
+		})
+	})
+	control.HandleFunc("POST /admin/events/{name}", func(rw http.ResponseWriter, req *http.Request) {
+		payload, err := io.ReadAll(io.LimitReader(req.Body, events.MaxPayloadSize+1))
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("failed to read payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		e, err := w.events.Fire(req.PathValue("name"), payload)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.observeEvent(e)
+		writeJSON(rw, http.StatusAccepted, e)
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gossip/pkg/consistenthash"
+	"gossip/pkg/pipeline"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var handoffTimeout = flag.Duration("handoff-timeout", 5*time.Second, "How long the previous owner of entities waits for the new owner to acknowledge their state, after which the new owner starts without it")
+
+// entityHandoff is the state of entities sent by their previous owner to
+// their new owner, such that delta pushes and pipeline deltas and rates
+// continue where the previous owner left off.
+type entityHandoff struct {
+	ID   uint64 `json:"id"`
+	From string `json:"from"`
+	// Lasts are the last values pushed, for delta pushes, and Window are the
+	// aggregates accumulated over the current window and not yet pushed,
+	// both by fetched entity id.
+	Lasts  map[string]int64           `json:"lasts,omitempty"`
+	Window map[string]windowAggregate `json:"window,omitempty"`
+	// Pipeline is the state of the pipeline stages, by entity and stage.
+	Pipeline map[string]map[string]pipeline.Observation `json:"pipeline,omitempty"`
+}
+
+// handoffAck acknowledges a handoff once its state is imported.
+type handoffAck struct {
+	ID       uint64 `json:"id"`
+	From     string `json:"from"`
+	Entities int    `json:"entities"`
+}
+
+// handoffs tracks the handoffs waiting for an acknowledgement.
+type handoffs struct {
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan handoffAck
+}
+
+// Expect returns a new handoff id and the channel its acknowledgement is sent on.
+func (hs *handoffs) Expect() (uint64, <-chan handoffAck) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.pending == nil {
+		hs.pending = make(map[uint64]chan handoffAck)
+	}
+	hs.seq++
+	acked := make(chan handoffAck, 1)
+	hs.pending[hs.seq] = acked
+	return hs.seq, acked
+}
+
+// Ack delivers an acknowledgement, returning false if the handoff isn't pending.
+func (hs *handoffs) Ack(a handoffAck) bool {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	acked, ok := hs.pending[a.ID]
+	if ok {
+		acked <- a
+		delete(hs.pending, a.ID)
+	}
+	return ok
+}
+
+// Forget stops waiting for a handoff's acknowledgement.
+func (hs *handoffs) Forget(id uint64) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	delete(hs.pending, id)
+}
+
+// handOff sends the state of entities this node owned at the last sync, and
+// which other nodes own in a ring, to their new owners. Entities that were
+// removed from the entity lists aren't handed off.
+func (w *worker) handOff(ring ringState) {
+	if ring.Ring == nil || len(w.polledOwned) == 0 {
+		return
+	}
+	listed := make(map[string]struct{}, len(ring.Entities))
+	for _, e := range ring.Entities {
+		listed[e] = struct{}{}
+	}
+	for _, e := range ring.Owned {
+		delete(listed, e)
+	}
+	var moved []string
+	for _, e := range w.polledOwned {
+		if _, ok := listed[e]; ok {
+			moved = append(moved, e)
+		}
+	}
+	for owner, entities := range ring.Ring.Assignments(moved...) {
+		if owner == "" || owner == w.hostname {
+			continue
+		}
+		if h, ok := w.exportHandoff(entities); ok {
+			go w.sendHandoff(owner, h)
+		}
+	}
+}
+
+// handOffDrained sends the state of every entity this node owns to their
+// owners in the ring without this node, and waits for them to acknowledge,
+// such that the state isn't lost when a draining node leaves.
+func (w *worker) handOffDrained() {
+	ch := consistenthash.New()
+	for _, m := range w.getMembers() {
+		if m.Name != w.hostname {
+			ch.AddWeightedBucket(m.Name, m.replicas())
+		}
+	}
+	if len(ch.Buckets()) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for owner, entities := range ch.Assignments(w.polledOwned...) {
+		h, ok := w.exportHandoff(entities)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.sendHandoff(owner, h)
+		}()
+	}
+	wg.Wait()
+}
+
+// exportHandoff returns the state held for entities, if there is any.
+func (w *worker) exportHandoff(entities []string) (entityHandoff, bool) {
+	h := entityHandoff{
+		From:     w.hostname,
+		Pipeline: w.pipeline.Export(entities),
+	}
+	h.Lasts, h.Window = w.pushState.Export(entities)
+	return h, len(h.Lasts) > 0 || len(h.Window) > 0 || len(h.Pipeline) > 0
+}
+
+// importHandoff imports the state sent by the previous owner of
+// entities, returning the number of entities it has state for.
+func (w *worker) importHandoff(h entityHandoff) int {
+	w.pushState.Import(h.Lasts, h.Window, time.Now())
+	w.pipeline.Import(h.Pipeline)
+	return handoffEntities(h)
+}
+
+// sendHandoff sends state to the new owner of its entities, and waits for the
+// acknowledgement. The state is dropped if the new owner can't be reached or
+// doesn't acknowledge in time, in which case it starts without it, pushing
+// its first values in full and dropping them from pipeline deltas and rates.
+func (w *worker) sendHandoff(owner string, h entityHandoff) {
+	var acked <-chan handoffAck
+	h.ID, acked = w.handoffs.Expect()
+	defer w.handoffs.Forget(h.ID)
+	data, err := json.Marshal(h)
+	if err != nil {
+		slog.Error("failed to encode handoff", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	entities := handoffEntities(h)
+	timeout := time.NewTimer(*handoffTimeout)
+	defer timeout.Stop()
+	if err := w.sendReliable(owner, append([]byte{messageHandoff}, data...)); err != nil {
+		w.metrics.handoffs.Inc("failed")
+		slog.Warn("failed to hand off entity state", slog.String("hostname", w.hostname), slog.String("member-name", owner), slog.Int("entity-count", entities), slog.Any("err", err))
+		return
+	}
+	select {
+	case a := <-acked:
+		w.metrics.handoffs.Inc("acked")
+		slog.Info("handed off entity state", slog.String("hostname", w.hostname), slog.String("member-name", owner), slog.Int("entity-count", a.Entities))
+	case <-timeout.C:
+		w.metrics.handoffs.Inc("timeout")
+		slog.Warn("entity state handoff was not acknowledged", slog.String("hostname", w.hostname), slog.String("member-name", owner), slog.Int("entity-count", entities), slog.Duration("timeout", *handoffTimeout))
+	}
+}
+
+// receiveHandoff imports the state sent by the previous owner of entities and acknowledges it.
+func (w *worker) receiveHandoff(msg []byte) {
+	var h entityHandoff
+	if err := json.Unmarshal(msg, &h); err != nil {
+		slog.Error("failed to decode handoff", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	w.metrics.handoffs.Inc("received")
+	a := handoffAck{ID: h.ID, From: w.hostname, Entities: w.importHandoff(h)}
+	slog.Info("received entity state", slog.String("hostname", w.hostname), slog.String("member-name", h.From), slog.Int("entity-count", a.Entities))
+	data, err := json.Marshal(a)
+	if err != nil {
+		slog.Error("failed to encode handoff acknowledgement", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	go func() {
+		if err := w.sendReliable(h.From, append([]byte{messageHandoffAck}, data...)); err != nil {
+			slog.Warn("failed to acknowledge handoff", slog.String("hostname", w.hostname), slog.String("member-name", h.From), slog.Any("err", err))
+		}
+	}()
+}
+
+// receiveHandoffAck delivers a handoff acknowledgement to the sender waiting for it.
+func (w *worker) receiveHandoffAck(msg []byte) {
+	var a handoffAck
+	if err := json.Unmarshal(msg, &a); err != nil {
+		slog.Error("failed to decode handoff acknowledgement", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	if !w.handoffs.Ack(a) {
+		slog.Warn("late handoff acknowledgement", slog.String("hostname", w.hostname), slog.String("member-name", a.From), slog.Uint64("id", a.ID))
+	}
+}
+
+// handoffEntities returns the number of entities a handoff has state for.
+func handoffEntities(h entityHandoff) int {
+	entities := make(map[string]struct{}, len(h.Lasts)+len(h.Pipeline))
+	for e := range h.Lasts {
+		entities[e] = struct{}{}
+	}
+	for e := range h.Window {
+		entities[e] = struct{}{}
+	}
+	for e := range h.Pipeline {
+		entities[e] = struct{}{}
+	}
+	return len(entities)
+}
+
+// sendReliable sends a message to a member over tcp.
+func (w *worker) sendReliable(name string, msg []byte) error {
+	for _, m := range w.list.Members() {
+		if m.Name == name {
+			return w.list.SendReliable(m, msg)
+		}
+	}
+	return fmt.Errorf("unknown member %q", name)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_handoff_rename(t *testing.T) {
+	const config = "stages: [{rate: {per: 1s}}, {rename: {regex: '^prices/(.*)$', replacement: 'etf.$1'}}]"
+	previous := newTestWorker(t, "a", config)
+	next := newTestWorker(t, "b", config)
+
+	previous.transform(map[string]int64{"prices/AAPL": 100})
+	values, names := previous.transform(map[string]int64{"prices/AAPL": 100})
+	previous.pushState.Pushed(values)
+	previous.pushState.Accumulate(map[string]int64{"prices/AAPL": 7}, names, time.Now())
+
+	h, ok := previous.exportHandoff([]string{"prices/AAPL", "prices/MSFT"})
+	if !ok {
+		t.Fatalf("expected state to hand off")
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	var received entityHandoff
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if entities := next.importHandoff(received); entities != 1 {
+		t.Fatalf("expected state for 1 entity, was: %d", entities)
+	}
+
+	values, names = next.transform(map[string]int64{"prices/AAPL": 100})
+	if _, ok := values["prices/AAPL"]; !ok || names["prices/AAPL"] != "etf.AAPL" {
+		t.Fatalf("expected the rate to continue from the handed off state, was: %v %v", values, names)
+	}
+	if changed := next.pushState.Changed(values); len(changed) != 0 {
+		t.Fatalf("expected the handed off last value to suppress an unchanged push, was: %v", changed)
+	}
+	next.pushState.Accumulate(map[string]int64{"prices/AAPL": 9}, names, time.Now())
+	aggregates := next.pushState.Take(time.Now(), time.Minute, true)
+	if a := aggregates["prices/AAPL"]; a.Entity != "etf.AAPL" || a.Count != 2 || a.Min != 7 || a.Max != 9 || a.Last != 9 {
+		t.Fatalf("expected the handed off window to merge into the new owner's, was: %+v", aggregates)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"gossip/pkg/gossipkeys"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// loadKeyring loads the gossip encryption keyring from a keys file.
+func loadKeyring(path string) (*memberlist.Keyring, error) {
+	keys, err := gossipkeys.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return memberlist.NewKeyring(keys.All, keys.Primary)
+}
+
+// watchKeys polls the keys file and applies any changes to the keyring.
+//
+// Kubernetes updates mounted secrets by swapping a symlink, so
+// we compare file contents rather than relying on modification times.
+func (w *worker) watchKeys(path string, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var lastHash [sha256.Size]byte
+	if contents, err := os.ReadFile(path); err == nil {
+		lastHash = sha256.Sum256(contents)
+	}
+	r := keyRotation{installed: make(map[string]time.Time)}
+	var pending *gossipkeys.Keys
+	for now := range tick.C {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read gossip keys file", slog.String("hostname", w.hostname), slog.Any("err", err))
+		} else if hash := sha256.Sum256(contents); hash != lastHash {
+			keys, err := gossipkeys.Parse(bytes.NewReader(contents))
+			if err != nil {
+				slog.Error("failed to parse gossip keys file", slog.String("hostname", w.hostname), slog.Any("err", err))
+			} else {
+				pending = &keys
+				lastHash = hash
+			}
+		}
+		if pending == nil {
+			continue
+		}
+		done, err := w.applyKeys(*pending, &r, now)
+		if err != nil {
+			slog.Error("failed to apply gossip keys", slog.String("hostname", w.hostname), slog.Any("err", err))
+			continue
+		}
+		if done {
+			slog.Info("applied gossip keys", slog.String("hostname", w.hostname), slog.String("primary", gossipkeys.Fingerprint(pending.Primary)), slog.Int("key-count", len(pending.All)))
+			pending = nil
+		}
+	}
+}
+
+// keyRotation is the progress of applying a keys file to the keyring.
+type keyRotation struct {
+	// installed is when keys were installed, by fingerprint; keys
+	// that were in the keyring already count as settled.
+	installed map[string]time.Time
+	// primaryAt is when the primary key was last changed.
+	primaryAt time.Time
+}
+
+// applyKeys moves the keyring towards a keys file in three steps, each taken
+// once the previous one has settled, returning if the keyring matches the file.
+//
+// Keys files reach nodes at different times, so new keys are installed first,
+// the new primary key is only used once it has been installed for the settle
+// period, and keys no longer present are only removed once the primary key has
+// been used for the settle period; peers that observe the file later can then
+// still decrypt this node's messages, and this node theirs.
+func (w *worker) applyKeys(keys gossipkeys.Keys, r *keyRotation, now time.Time) (bool, error) {
+	for _, key := range keys.All {
+		if slices.ContainsFunc(w.keyring.GetKeys(), func(k []byte) bool { return bytes.Equal(k, key) }) {
+			continue
+		}
+		if err := w.keyring.AddKey(key); err != nil {
+			return false, err
+		}
+		r.installed[gossipkeys.Fingerprint(key)] = now
+		slog.Info("installed gossip key", slog.String("hostname", w.hostname), slog.String("key", gossipkeys.Fingerprint(key)))
+	}
+	if !bytes.Equal(w.keyring.GetPrimaryKey(), keys.Primary) {
+		if now.Sub(r.installed[gossipkeys.Fingerprint(keys.Primary)]) < *keysSettle {
+			return false, nil
+		}
+		if err := w.keyring.UseKey(keys.Primary); err != nil {
+			return false, err
+		}
+		r.primaryAt = now
+		slog.Info("using gossip key", slog.String("hostname", w.hostname), slog.String("key", gossipkeys.Fingerprint(keys.Primary)))
+	}
+	var stale [][]byte
+	for _, key := range w.keyring.GetKeys() {
+		if !keys.Contains(key) {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) == 0 {
+		return true, nil
+	}
+	if now.Sub(r.primaryAt) < *keysSettle {
+		return false, nil
+	}
+	for _, key := range stale {
+		if err := w.keyring.RemoveKey(key); err != nil {
+			return false, err
+		}
+		delete(r.installed, gossipkeys.Fingerprint(key))
+		slog.Info("removed gossip key", slog.String("hostname", w.hostname), slog.String("key", gossipkeys.Fingerprint(key)))
+	}
+	return true, nil
+}
+
+// keyringStatus is the admin representation of the keyring, using
+// key fingerprints so keys are never exposed.
+type keyringStatus struct {
+	Primary string   `json:"primary"`
+	Keys    []string `json:"keys"`
+}
+
+// registerKeyringHandlers adds the keyring admin endpoints.
+//
+// Keys are operated on one node at a time; to rotate keys across the cluster
+// install the new key on every node, then use it on every node, then remove
+// the old key from every node. Updating the mounted keys file performs
+// all three steps on each node, waiting the settle period between them.
+//
+// The endpoints that change the keyring are added to the control mux.
+func (w *worker) registerKeyringHandlers(mux, control *http.ServeMux) {
+	mux.HandleFunc("GET /admin/keys", w.withKeyring(func(rw http.ResponseWriter, req *http.Request) {
+		status := keyringStatus{
+			Primary: gossipkeys.Fingerprint(w.keyring.GetPrimaryKey()),
+		}
+		for _, key := range w.keyring.GetKeys() {
+			status.Keys = append(status.Keys, gossipkeys.Fingerprint(key))
+		}
+		writeJSON(rw, http.StatusOK, status)
+	}))
+	control.HandleFunc("POST /admin/keys/install", w.withKey((*memberlist.Keyring).AddKey))
+	control.HandleFunc("POST /admin/keys/use", w.withKey((*memberlist.Keyring).UseKey))
+	control.HandleFunc("POST /admin/keys/remove", w.withKey((*memberlist.Keyring).RemoveKey))
+}
+
+// withKeyring returns a handler that fails if encryption is not enabled.
+func (w *worker) withKeyring(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if w.keyring == nil {
+			http.Error(rw, "gossip encryption is not enabled", http.StatusConflict)
+			return
+		}
+		handler(rw, req)
+	}
+}
+
+// withKey returns a handler that applies a keyring operation
+// to the base64 encoded `key` form value.
+func (w *worker) withKey(op func(*memberlist.Keyring, []byte) error) http.HandlerFunc {
+	return w.withKeyring(func(rw http.ResponseWriter, req *http.Request) {
+		key, err := gossipkeys.Decode(req.FormValue("key"))
+		if err != nil {
+			http.Error(rw, "invalid key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := op(w.keyring, key); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("gossip keyring updated", slog.String("hostname", w.hostname), slog.String("path", req.URL.Path), slog.String("key", gossipkeys.Fingerprint(key)))
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"gossip/pkg/gossipkeys"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func Test_applyKeys(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	keyring, err := memberlist.NewKeyring(nil, oldKey)
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	w := &worker{hostname: "a", keyring: keyring}
+	r := keyRotation{installed: make(map[string]time.Time)}
+	keys := gossipkeys.Keys{Primary: newKey, All: [][]byte{newKey}}
+	started := time.Unix(1000, 0)
+
+	steps := []struct {
+		at      time.Duration
+		done    bool
+		primary []byte
+		count   int
+	}{
+		{at: 0, primary: oldKey, count: 2},
+		{at: *keysSettle / 2, primary: oldKey, count: 2},
+		{at: *keysSettle, primary: newKey, count: 2},
+		{at: *keysSettle + *keysSettle/2, primary: newKey, count: 2},
+		{at: 2 * *keysSettle, done: true, primary: newKey, count: 1},
+	}
+	for _, step := range steps {
+		done, err := w.applyKeys(keys, &r, started.Add(step.at))
+		if err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+		if done != step.done || !bytes.Equal(keyring.GetPrimaryKey(), step.primary) || len(keyring.GetKeys()) != step.count {
+			t.Fatalf("unexpected keyring after %v; done: %v, primary: %s, keys: %d", step.at, done, gossipkeys.Fingerprint(keyring.GetPrimaryKey()), len(keyring.GetKeys()))
+		}
+	}
+}
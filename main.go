@@ -1,22 +1,34 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"gossip/pkg/consistenthash"
+	"gossip/pkg/events"
+	"gossip/pkg/gossipkv"
+	"gossip/pkg/heartbeat"
+	"gossip/pkg/nodemeta"
+	"gossip/pkg/pipeline"
+	"gossip/pkg/polling"
+	"gossip/pkg/scheduler"
+	"gossip/pkg/sink"
+	"gossip/pkg/spool"
 	"gossip/pkg/types"
+	"gossip/pkg/upstream"
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,30 +36,136 @@ import (
 )
 
 var (
-	gossipAddr = flag.String("gossip-addr", "gossip-members.gossip", "The gossip address")
+	gossipAddr       = flag.String("gossip-addr", "gossip-members.gossip", "The gossip address")
+	clusterID        = flag.String("cluster-id", "gossip", "The cluster id; nodes with a different cluster id are rejected")
+	zone             = flag.String("zone", "", "The zone this node advertises to the cluster")
+	interval         = flag.Duration("interval", 10*time.Second, "How often to refresh the entity list, and without a poll config, to fetch and push entity data")
+	pipelineFile     = flag.String("pipeline", "", "The yaml file of pipeline stages that filter and transform fetched values before they're pushed")
+	kvTombstoneTTL   = flag.Duration("kv-tombstone-ttl", gossipkv.DefaultTombstoneTTL, "How long deleted shared state keys are remembered, which must exceed the time a delete takes to reach every node")
+	pipelineDryRun   = flag.Bool("pipeline-dry-run", false, "Print the effect of the pipeline on every fetched value to stdout, but push the values unchanged")
+	pushMode         = flag.String("push-mode", pushModeAll, "Which fetched values to push; `all` values, or only values that changed since the last push with `delta`")
+	aggregateWindow  = flag.Duration("aggregate-window", 0, "If set, accumulate fetched values over the window and push their min, max, last and count once per window")
+	pollConfig       = flag.String("poll-config", "", "The yaml file of per-entity and per-group polling intervals; every entity polls at the interval if unset")
+	tickJitter       = flag.Duration("tick-jitter", 500*time.Millisecond, "The maximum random delay added to each tick")
+	settle           = flag.Duration("ready-settle", 20*time.Second, "How long the ring must be unchanged before the node reports ready")
+	adminAddr        = flag.String("admin-addr", ":8080", "The admin http server bind address")
+	controlAddr      = flag.String("admin-control-addr", "127.0.0.1:8081", "The bind address of the admin endpoints that change the node or the cluster, such as key rotation, drains, events, queries and shared state writes; keep it unreachable from untrusted networks")
+	keysFile         = flag.String("gossip-keys-file", "", "The file holding gossip encryption keys, one base64 key per line with the primary key first")
+	keysPoll         = flag.Duration("gossip-keys-poll", 10*time.Second, "How often to check the gossip keys file for changes")
+	keysSettle       = flag.Duration("gossip-keys-settle", 2*time.Minute, "How long a new gossip key is installed before it is used as the primary key, and a new primary key is used before old keys are removed; must exceed the time a keys file update takes to reach every node")
+	dataPlaneURL     = flag.String("data-plane-url", "http://data-plane:3000", "The data-plane base url")
+	metricSinkURL    = flag.String("metric-sink-url", "http://metric-sink:3000", "The metric-sink base url; if empty values are only pushed to the additional sinks")
+	upstreamTimeout  = flag.Duration("upstream-timeout", 5*time.Second, "The timeout for a single upstream request attempt")
+	upstreamAttempts = flag.Int("upstream-attempts", 3, "The maximum attempts for an upstream request")
+	batchSize        = flag.Int("batch-size", 250, "The maximum number of entities fetched from the data-plane per request")
+	fetchParallelism = flag.Int("fetch-parallelism", 4, "The maximum number of concurrent batch fetches")
+	spoolDir         = flag.String("spool-dir", "", "The directory to spool failed metric-sink submissions to; spooling is disabled if unset")
+	spoolMaxBytes    = flag.Int64("spool-max-bytes", spool.DefaultMaxBytes, "The maximum size of the spool; the oldest submissions are dropped beyond it")
+	spoolMaxAge      = flag.Duration("spool-max-age", spool.DefaultMaxAge, "The maximum age of spooled submissions")
+	spoolReplayMax   = flag.Int("spool-replay-max", 100, "The maximum number of spooled submissions replayed per tick, after the tick's live push; 0 is unlimited")
+	capacity         = flag.Uint("capacity", nodemeta.DefaultCapacity, "The relative capacity weight this node advertises to the cluster")
+	drainTimeout     = flag.Duration("drain-timeout", 0, "How long to keep working while peers take over before leaving on SIGTERM; defaults to what's left of the termination grace period once the node finishes, at most twice the interval")
 )
 
+// buildVersion is the build version advertised in node metadata.
+//
+// It is set at build time with `-ldflags "-X main.buildVersion=..."`.
+var buildVersion = "dev"
+
 func main() {
 	flag.Parse()
+	if *capacity == 0 || *capacity > math.MaxUint16 {
+		panic(fmt.Sprintf("Invalid capacity: %d", *capacity))
+	}
+	if *pushMode != pushModeAll && *pushMode != pushModeDelta {
+		panic(fmt.Sprintf("Invalid push mode: %q", *pushMode))
+	}
 	cfg := memberlist.DefaultLANConfig()
 	cfg.Logger = log.New(io.Discard, "", 0)
+	cfg.Label = *clusterID
+	if *keysFile != "" {
+		keyring, err := loadKeyring(*keysFile)
+		if err != nil {
+			panic("Failed to load gossip keys: " + err.Error())
+		}
+		cfg.Keyring = keyring
+	}
 
 	shutdown := make(chan os.Signal, 3)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 	w := &worker{
-		shutdown: shutdown,
+		started:         time.Now(),
+		shutdown:        shutdown,
+		drain:           make(chan struct{}, 1),
+		protocolVersion: cfg.ProtocolVersion,
+		meta: nodemeta.Meta{
+			ClusterID:    *clusterID,
+			Zone:         *zone,
+			Capacity:     uint16(*capacity),
+			BuildVersion: buildVersion,
+			State:        nodemeta.StateJoining,
+		},
 	}
 	w.hostname, _ = os.Hostname()
+	// seed the tick sequence with the start time so idempotency keys aren't reused across restarts.
+	w.tickSeq.Store(uint64(w.started.UnixMilli()))
+	w.keyring = cfg.Keyring
+	w.pollConfig = polling.Config{Tick: *interval, Default: *interval}
+	if *pollConfig != "" {
+		var err error
+		if w.pollConfig, err = polling.Load(*pollConfig); err != nil {
+			panic("Failed to load poll config: " + err.Error())
+		}
+	}
+	w.scheduler = &scheduler.Scheduler{Interval: w.pollConfig.TickOrDefault(), Jitter: *tickJitter}
+	if err := w.checkDrainTimeout(); err != nil {
+		panic("Invalid drain timeout: " + err.Error())
+	}
+	w.metrics = newWorkerMetrics(w)
+	w.events = w.newEventBus()
+	w.queries = w.newQueries()
+	w.heartbeats = w.newHeartbeats()
+	w.kv = gossipkv.New(gossipkv.Options{Node: w.hostname, TombstoneTTL: *kvTombstoneTTL})
+	w.counters = gossipkv.NewCounters(gossipkv.CounterOptions{Node: w.hostname, Started: w.started, TombstoneTTL: *kvTombstoneTTL})
+	var err error
+	if w.sources, err = w.newSources(); err != nil {
+		panic("Failed to configure sources: " + err.Error())
+	}
+	w.metricSink = w.newUpstreamClient("metric-sink")
+	if w.pipeline, err = w.loadPipeline(); err != nil {
+		panic("Failed to load pipeline: " + err.Error())
+	}
+	if w.fanout, err = w.newFanout(); err != nil {
+		panic("Failed to create sinks: " + err.Error())
+	}
+	if *spoolDir != "" {
+		if w.spool, err = spool.Open(*spoolDir, spool.Options{MaxBytes: *spoolMaxBytes, MaxAge: *spoolMaxAge}); err != nil {
+			panic("Failed to open spool: " + err.Error())
+		}
+	}
 	cfg.Events = w
+	cfg.Delegate = w
+	cfg.Alive = w
+	cfg.Merge = w
 
 	list, err := memberlist.Create(cfg)
 	if err != nil {
 		panic("Failed to create memberlist: " + err.Error())
 	}
 	w.list = list
+	if w.keyring != nil {
+		go w.watchKeys(*keysFile, *keysPoll)
+	}
+	w.startAdmin(*adminAddr, *controlAddr)
 	if err := w.tryJoin(); err != nil {
+		if errors.Is(err, errInterrupted) {
+			w.doShutdown()
+			return
+		}
 		panic("Failed to join memberlist: " + err.Error())
 	}
+	w.setState(nodemeta.StateActive)
+	w.joined.Store(true)
 	if err := w.runLoop(); err != nil {
 		panic("Worker failure: " + err.Error())
 	}
@@ -58,21 +176,77 @@ type worker struct {
 	hostname string
 	list     *memberlist.Memberlist
 	shutdown <-chan os.Signal
+	drain    chan struct{}
+
+	protocolVersion uint8
+	keyring         *memberlist.Keyring
+	admin           *http.Server
+	control         *http.Server
+	metrics         *workerMetrics
+	scheduler       *scheduler.Scheduler
+	sources         []*source
+	metricSink      *upstream.Client
+	spool           *spool.Spool
+	fanout          *sink.Fanout
+	pollConfig      polling.Config
+	polls           polling.Queue
+	pushState       pushState
+	pipeline        *pipeline.Pipeline
+
+	lists    entityLists
+	handoffs handoffs
+	// listVersions, infos, listedAt and the polled fields are only used by the run loop.
+	listVersions map[string]uint64
+	infos        map[string]types.EntityInfo
+	// infosPending is set while some entity metadata failed to fetch.
+	infosPending      bool
+	listedAt          time.Time
+	polledOwned       []string
+	polledFingerprint uint64
+	polledTakenOver   []string
+
+	metaMu      sync.Mutex
+	meta        nodemeta.Meta
+	incarnation uint32
+
+	peersMu sync.Mutex
+	peers   map[string]string
+
+	ringMu     sync.Mutex
+	ring       ringState
+	epochClock epochClock
+
+	events           *events.Bus
+	queries          *events.Queries
+	heartbeats       *heartbeat.Tracker
+	kv               *gossipkv.Map
+	counters         *gossipkv.Counters
+	refreshRequested atomic.Bool
+	// pausedUntil is the unix time in nanoseconds pushing is paused until.
+	pausedUntil atomic.Int64
+
+	started  time.Time
+	joined   atomic.Bool
+	lastTick atomic.Int64
+	tickSeq  atomic.Uint64
 }
 
-func (w worker) NotifyJoin(n *memberlist.Node) {
+func (w *worker) NotifyJoin(n *memberlist.Node) {
+	w.trackPeer(n, true)
 	slog.Info("node joined", slog.String("hostname", w.hostname), slog.String("member-name", n.Name))
 }
 
-func (w worker) NotifyLeave(n *memberlist.Node) {
+func (w *worker) NotifyLeave(n *memberlist.Node) {
+	w.trackPeer(n, false)
 	slog.Info("node left", slog.String("hostname", w.hostname), slog.String("member-name", n.Name))
 }
 
-func (w worker) NotifyUpdate(n *memberlist.Node) {
+func (w *worker) NotifyUpdate(n *memberlist.Node) {
+	w.trackPeer(n, true)
 	slog.Info("node update", slog.String("hostname", w.hostname), slog.String("member-name", n.Name))
 }
 
-func (w worker) tryJoin() (err error) {
+func (w *worker) tryJoin() (err error) {
 	deadline := time.NewTimer(60 * time.Second)
 	defer deadline.Stop()
 	tick := time.NewTicker(10 * time.Second)
@@ -82,11 +256,14 @@ func (w worker) tryJoin() (err error) {
 		case <-deadline.C:
 			return fmt.Errorf("join deadline expired after 60s")
 		case <-w.shutdown:
-			return nil
+			return errInterrupted
+		case <-w.drain:
+			return errInterrupted
 		case <-tick.C:
 			var ips []net.IP
 			ips, err = net.LookupIP(*gossipAddr)
 			if err != nil {
+				w.metrics.joinAttempts.Inc(result(err))
 				continue
 			}
 			var joinList []string
@@ -95,6 +272,7 @@ func (w worker) tryJoin() (err error) {
 			}
 			slog.Info("attempting to join based on DNS lookup.", slog.String("hostname", w.hostname), slog.String("members", strings.Join(joinList, ",")))
 			_, err = w.list.Join(joinList)
+			w.metrics.joinAttempts.Inc(result(err))
 			if err != nil {
 				continue
 			}
@@ -103,112 +281,174 @@ func (w worker) tryJoin() (err error) {
 	}
 }
 
-func (w worker) runLoop() error {
-	tick := time.NewTicker(10 * time.Second)
-	defer tick.Stop()
+// runLoop ticks on the schedule until the node is stopped.
+//
+// A tick that overruns the interval skips the slots it missed rather
+// than starting the following ticks back to back.
+//
+// SIGTERM or a drain request starts draining, and the node leaves once the drain
+// timeout elapses; SIGINT, or a second SIGTERM while draining, leaves immediately.
+func (w *worker) runLoop() error {
+	next := w.scheduler.Next(time.Now())
+	timer := time.NewTimer(time.Until(next.At))
+	defer timer.Stop()
+	var drained <-chan time.Time
 	for {
 		select {
-		case <-tick.C:
-			entities, err := w.getEntityList()
-			if err != nil {
-				slog.Error("failed to get entities", slog.String("hostname", w.hostname), slog.Any("err", err))
-				continue
+		case <-timer.C:
+			started := time.Now()
+			w.lastTick.Store(started.UnixNano())
+			w.metrics.tickLag.Observe(max(started.Sub(next.At), 0).Seconds())
+			w.tick(started)
+			w.beat()
+			next = w.scheduler.Next(time.Now())
+			if next.Skipped > 0 {
+				w.metrics.ticksSkipped.Add(float64(next.Skipped))
+				slog.Warn("tick overran the interval", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)), slog.Int("skipped", next.Skipped))
 			}
-			members := w.getMembers()
-			ch := consistenthash.New()
-			ch.AddBuckets(members...)
-			var matchedEntities []string
-			for _, e := range entities {
-				if ch.Assignment(e) == w.hostname {
-					matchedEntities = append(matchedEntities, e)
-				}
+			timer.Reset(time.Until(next.At))
+		case <-w.drain:
+			if drained == nil {
+				drained = w.startDrain()
 			}
-			slog.Info("fetching and pushing entity data", slog.String("hostname", w.hostname), slog.Int("entity-count", len(matchedEntities)))
-			if err := w.getAndPushEntities(matchedEntities...); err != nil {
-				slog.Error("failed to get and push entity data", slog.String("hostname", w.hostname), slog.Any("err", err))
+		case sig := <-w.shutdown:
+			if sig == syscall.SIGTERM && drained == nil {
+				drained = w.startDrain()
 				continue
 			}
-			slog.Info("fetching and pushing entity data complete!", slog.String("hostname", w.hostname), slog.Int("entity-count", len(matchedEntities)))
-		case <-w.shutdown:
+			w.doShutdown()
+			return nil
+		case <-drained:
+			w.finishDrain()
 			w.doShutdown()
 			return nil
 		}
 	}
 }
 
-func (w worker) getEntityList() (entities []string, err error) {
-	started := time.Now()
-	slog.Info("getting entity list", slog.String("hostname", w.hostname))
-	defer func() {
-		if err != nil {
-			slog.Error("getting entity list failed", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)), slog.Any("err", err))
-		} else {
-			slog.Info("getting entity list success", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)))
-		}
-	}()
-	var res *http.Response
-	res, err = http.Get("http://data-plane:3000/")
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	err = json.NewDecoder(res.Body).Decode(&entities)
-	return
+// tickTimeout returns how long a tick may take to fetch and push the
+// entities that are due.
+func tickTimeout() time.Duration {
+	return *interval
 }
 
-func (w worker) getAndPushEntities(entities ...string) error {
-	data, err := w.getEntityData(entities...)
-	if err != nil {
-		return err
-	}
-	return w.pushEntities(data.Entities)
+// replayBudget returns how long a tick may replay spooled submissions
+// after its live push.
+func (w *worker) replayBudget() time.Duration {
+	return w.scheduler.IntervalOrDefault() / 2
 }
 
-func (w worker) getEntityData(entities ...string) (data types.DataPlaneResponse, err error) {
-	started := time.Now()
-	slog.Info("getting entity data", slog.String("hostname", w.hostname))
+// maxTickDuration returns the longest a tick may take, including the
+// spool replay after its live push.
+//
+// With a poll config, the scheduler interval can be much shorter than a
+// tick is allowed to take, so anything that judges whether ticks still
+// complete, such as health checks and standby takeovers, uses this limit.
+func (w *worker) maxTickDuration() time.Duration {
+	return tickTimeout() + w.replayBudget()
+}
+
+// tick fetches and pushes the data for the owned entities that are due.
+//
+// The tick is bounded by the tick timeout and the replay budget so a
+// hung upstream can't wedge the run loop.
+func (w *worker) tick(started time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), tickTimeout())
+	defer cancel()
+	if removed := w.kv.GC(); removed > 0 {
+		slog.Info("collected shared state tombstones", slog.String("hostname", w.hostname), slog.Int("removed", removed))
+	}
+	if folded := w.counters.Retire(w.peerNames()); folded > 0 {
+		slog.Info("folded the counter slots of dead processes", slog.String("hostname", w.hostname), slog.Int("folded", folded))
+	}
+	paused := w.pushPaused(started)
+	entities, listed := w.refreshEntities(ctx, started)
+	ring := w.refreshRing(entities)
+	if listed || ring.Fingerprint != w.polledFingerprint || !slices.Equal(ring.TakenOver, w.polledTakenOver) {
+		w.syncPolls(ring, started)
+	}
+	if paused {
+		slog.Info("pushing paused", slog.String("hostname", w.hostname))
+		return
+	}
 	defer func() {
-		if err != nil {
-			slog.Error("getting entity data failed", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)), slog.Any("err", err))
-		} else {
-			slog.Info("getting entity data success", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)))
-		}
+		// spooled submissions are replayed after the live push, within their own
+		// budget, such that a backlog can't starve live data of the tick's timeout
+		// and a slow live push can't starve the backlog.
+		replayCtx, cancel := context.WithTimeout(context.Background(), w.replayBudget())
+		defer cancel()
+		w.replaySpool(replayCtx, *spoolReplayMax)
 	}()
-	u, _ := url.Parse("http://data-plane:3000/data")
-	u.RawQuery = fmt.Sprintf("s=%s", strings.Join(entities, ","))
-	var res *http.Response
-	res, err = http.Get(u.String())
+	// entities due before the middle of the tick are polled now, so jitter can't defer them a whole tick.
+	due := w.polls.Due(started.Add(w.scheduler.IntervalOrDefault() / 2))
+	w.metrics.entities.Set(float64(len(entities)))
+	w.metrics.entitiesOwned.Set(float64(len(ring.Owned)))
+	w.metrics.entitiesTakenOver.Set(float64(ring.TakenOverEntities))
+	w.metrics.entitiesDue.Set(float64(len(due)))
+	if len(due) == 0 {
+		return
+	}
+	slog.Info("fetching and pushing entity data", slog.String("hostname", w.hostname), slog.Int("entity-count", len(due)))
+	data, err := w.getAndPushEntities(ctx, w.tickSeq.Add(1), ring, due...)
+	w.metrics.tickDuration.Observe(time.Since(started).Seconds())
+	if *aggregateWindow > 0 {
+		if err := w.flushAggregates(ctx, ring, started, false); err != nil {
+			slog.Error("failed to push aggregates", slog.String("hostname", w.hostname), slog.Any("err", err))
+		}
+	}
 	if err != nil {
+		slog.Error("failed to get and push entity data", slog.String("hostname", w.hostname), slog.Int("fetched-count", len(data.Entities)), slog.Any("err", err))
 		return
 	}
-	defer res.Body.Close()
-	err = json.NewDecoder(res.Body).Decode(&data)
-	return
+	slog.Info("fetching and pushing entity data complete!", slog.String("hostname", w.hostname), slog.Int("entity-count", len(data.Entities)))
 }
 
-func (w worker) pushEntities(values map[string]int64) error {
-	started := time.Now()
-	slog.Info("pushing entity data", slog.String("hostname", w.hostname))
-	defer func() {
-		slog.Info("pushing entity data complete", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)))
-	}()
-	var submission types.MetricSinkSubmission
+// newSubmission returns a metric-sink submission for a batch of entity values
+// fenced with the epoch of the ring used to assign them.
+func (w *worker) newSubmission(b batch, values map[string]int64) (submission types.MetricSinkSubmission) {
+	submission.IdempotencyKey = w.idempotencyKey(b)
 	for key, value := range values {
 		submission.Values = append(submission.Values, types.MetricSinkSubmissionValue{
 			Entity:   key,
 			Hostname: w.hostname,
 			Value:    value,
+			Epoch:    b.Epoch,
 		})
 	}
-	body, err := json.Marshal(submission)
-	if err != nil {
-		return err
+	return
+}
+
+func (w *worker) pushSubmission(ctx context.Context, submission types.MetricSinkSubmission) (response types.MetricSinkSubmitResponse, err error) {
+	started := time.Now()
+	slog.Info("pushing entity data", slog.String("hostname", w.hostname))
+	defer func() {
+		if err != nil {
+			slog.Error("pushing entity data failed", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)), slog.Any("err", err))
+		} else {
+			slog.Info("pushing entity data complete", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)))
+		}
+	}()
+	if err = w.metricSink.PostJSON(ctx, *metricSinkURL+"/submit", submission, &response); err != nil {
+		return
+	}
+	if response.Duplicate {
+		w.metrics.pushDuplicates.Inc()
+		slog.Info("metric-sink already applied submission", slog.String("hostname", w.hostname), slog.String("idempotency-key", submission.IdempotencyKey))
+	}
+	if len(response.Rejected) > 0 {
+		w.metrics.pushRejected.Add(float64(len(response.Rejected)))
+		slog.Warn("metric-sink rejected stale values", slog.String("hostname", w.hostname), slog.Int("rejected-count", len(response.Rejected)), slog.String("first-entity", response.Rejected[0].Entity), slog.String("first-reason", response.Rejected[0].Reason))
+	}
+	if len(response.Conflicts) > 0 {
+		w.metrics.pushConflicts.Add(float64(len(response.Conflicts)))
+		slog.Warn("metric-sink detected split ownership", slog.String("hostname", w.hostname), slog.Int("conflict-count", len(response.Conflicts)), slog.String("first-entity", response.Conflicts[0]))
 	}
-	_, err = http.Post("http://metric-sink:3000/submit", "application/json", bytes.NewReader(body))
-	return err
+	return
 }
 
-func (w worker) getMembers() (memberNames []string) {
+// getMembers returns the members eligible for entity assignment, that is
+// members advertising valid metadata in the active state.
+func (w *worker) getMembers() (output []member) {
 	members := w.list.Members()
 	slices.SortFunc(members, func(i, j *memberlist.Node) int {
 		if i.Name < j.Name {
@@ -220,18 +460,49 @@ func (w worker) getMembers() (memberNames []string) {
 		return 1
 	})
 	for _, m := range members {
-		memberNames = append(memberNames, m.Name)
+		meta, err := nodemeta.Decode(m.Meta)
+		if err != nil {
+			slog.Warn("skipping member with invalid metadata", slog.String("hostname", w.hostname), slog.String("member-name", m.Name), slog.Any("err", err))
+			continue
+		}
+		// a draining node keeps itself in its own ring until it leaves.
+		if meta.State != nodemeta.StateActive && !(meta.State == nodemeta.StateDraining && m.Name == w.hostname) {
+			continue
+		}
+		output = append(output, member{Name: m.Name, Meta: meta})
 	}
 	return
 }
 
-func (w worker) doShutdown() {
+// member is a cluster member with its decoded metadata.
+type member struct {
+	Name string
+	Meta nodemeta.Meta
+}
+
+// replicas returns the number of hashring replicas for the member
+// scaled by its advertised capacity.
+func (m member) replicas() int {
+	return consistenthash.DefaultReplicas * int(m.Meta.CapacityOrDefault()) / nodemeta.DefaultCapacity
+}
+
+func (w *worker) doShutdown() {
 	slog.Info("shutting down", slog.String("hostname", w.hostname))
-	if err := w.list.Leave(10 * time.Second); err != nil {
+	if err := w.list.Leave(leaveTimeout); err != nil {
 		slog.Error("failed to leave cluster", slog.String("hostname", w.hostname), slog.Any("err", err))
 	}
 	if err := w.list.Shutdown(); err != nil {
 		slog.Error("failed to shutdown", slog.String("hostname", w.hostname), slog.Any("err", err))
 	}
+	w.closeFanout()
+	if err := w.pipeline.Close(); err != nil {
+		slog.Error("failed to close pipeline", slog.String("hostname", w.hostname), slog.Any("err", err))
+	}
+	if w.spool != nil {
+		if err := w.spool.Close(); err != nil {
+			slog.Error("failed to close spool", slog.String("hostname", w.hostname), slog.Any("err", err))
+		}
+	}
+	w.stopAdmin()
 	slog.Info("shutdown complete", slog.String("hostname", w.hostname))
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"gossip/pkg/gossipkv"
+	"gossip/pkg/pipeline"
+	"gossip/pkg/scheduler"
+	"gossip/pkg/types"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// newTestWorker returns a worker with a pipeline, metrics and the
+// gossiped state, but no memberlist.
+func newTestWorker(t *testing.T, hostname, config string) *worker {
+	t.Helper()
+	w := &worker{hostname: hostname, drain: make(chan struct{}, 1), scheduler: &scheduler.Scheduler{}}
+	w.metrics = newWorkerMetrics(w)
+	p, err := pipeline.Parse([]byte(config))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	w.pipeline = p
+	w.events = w.newEventBus()
+	w.queries = w.newQueries()
+	w.heartbeats = w.newHeartbeats()
+	w.kv = gossipkv.New(gossipkv.Options{Node: hostname})
+	w.counters = gossipkv.NewCounters(gossipkv.CounterOptions{Node: hostname, Started: time.UnixMilli(1)})
+	return w
+}
+
+// startTestMemberlist creates a memberlist for a worker on a loopback
+// port, which is shut down when the test ends.
+func startTestMemberlist(t *testing.T, w *worker) {
+	t.Helper()
+	cfg := memberlist.DefaultLocalConfig()
+	cfg.Name = w.hostname
+	cfg.BindAddr = "127.0.0.1"
+	cfg.BindPort = 0
+	cfg.Logger = log.New(io.Discard, "", 0)
+	cfg.Events = w
+	cfg.Delegate = w
+	list, err := memberlist.Create(cfg)
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	t.Cleanup(func() { _ = list.Shutdown() })
+	w.list = list
+}
+
+// metricValue returns the value of a worker's metric series, formatted
+// like `name{label="value"}`, or an empty string if it isn't set.
+func metricValue(t *testing.T, w *worker, series string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := w.metrics.registry.WriteTo(&buf); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// deliver hands the messages a worker queued for broadcast to another
+// worker, like gossip would, including their retransmissions, and returns
+// the number of distinct messages.
+func deliver(t *testing.T, from, to *worker) int {
+	t.Helper()
+	distinct := make(map[string]struct{})
+	for {
+		msgs := from.GetBroadcasts(0, 64*1024)
+		if len(msgs) == 0 {
+			return len(distinct)
+		}
+		for _, msg := range msgs {
+			to.NotifyMsg(msg)
+			distinct[string(msg)] = struct{}{}
+		}
+	}
+}
+
+// setFlag sets a flag value for the duration of a test.
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	t.Helper()
+	previous := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = previous })
+}
+
+// startTestDataPlane serves a data-plane for a worker's unnamed source,
+// responding with the values of the requested entities, or with the
+// status of fail if it returns non-zero for the requested entities.
+func startTestDataPlane(t *testing.T, w *worker, values map[string]int64, fail func(entities []string) int) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		entities := strings.Split(req.URL.Query().Get("s"), ",")
+		if fail != nil {
+			if status := fail(entities); status != 0 {
+				rw.WriteHeader(status)
+				return
+			}
+		}
+		var response types.DataPlaneResponse
+		response.Entities = make(map[string]int64, len(entities))
+		for _, entity := range entities {
+			if value, ok := values[entity]; ok {
+				response.Entities[entity] = value
+			}
+		}
+		_ = json.NewEncoder(rw).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	w.sources = []*source{{URL: server.URL, Client: w.newUpstreamClient("data-plane")}}
+}
+
+// startTestMetricSink serves a metric-sink for a worker, which passes
+// each submission to respond.
+func startTestMetricSink(t *testing.T, w *worker, respond func(submission types.MetricSinkSubmission) (types.MetricSinkSubmitResponse, int)) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var submission types.MetricSinkSubmission
+		if err := json.NewDecoder(req.Body).Decode(&submission); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response, status := respond(submission)
+		if status != http.StatusOK {
+			rw.WriteHeader(status)
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	setFlag(t, metricSinkURL, server.URL)
+	w.metricSink = w.newUpstreamClient("metric-sink")
+}
@@ -0,0 +1,46 @@
+package main
+
+import "gossip/pkg/types"
+
+// dedupWindow remembers the responses to the most recent submissions by
+// idempotency key, evicting the oldest key once the window is full.
+//
+// It is not safe for concurrent use.
+type dedupWindow struct {
+	keys      []string
+	next      int
+	responses map[string]types.MetricSinkSubmitResponse
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		keys:      make([]string, size),
+		responses: make(map[string]types.MetricSinkSubmitResponse, size),
+	}
+}
+
+// Get returns the original response for a key, if the key is in the window.
+func (dw *dedupWindow) Get(key string) (response types.MetricSinkSubmitResponse, ok bool) {
+	if key == "" {
+		return
+	}
+	response, ok = dw.responses[key]
+	return
+}
+
+// Add records the response for a key.
+//
+// Submissions without a key are never deduplicated.
+func (dw *dedupWindow) Add(key string, response types.MetricSinkSubmitResponse) {
+	if key == "" {
+		return
+	}
+	if _, ok := dw.responses[key]; ok {
+		dw.responses[key] = response
+		return
+	}
+	delete(dw.responses, dw.keys[dw.next])
+	dw.keys[dw.next] = key
+	dw.next = (dw.next + 1) % len(dw.keys)
+	dw.responses[key] = response
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"gossip/pkg/types"
+	"testing"
+)
+
+func Test_dedupWindow(t *testing.T) {
+	dw := newDedupWindow(2)
+	dw.Add("a", types.MetricSinkSubmitResponse{Accepted: 1})
+	dw.Add("b", types.MetricSinkSubmitResponse{Accepted: 2})
+	if response, ok := dw.Get("a"); !ok || response.Accepted != 1 {
+		t.Fatalf("expected the original response for a, was: %+v %v", response, ok)
+	}
+
+	// re-adding a key doesn't take another slot.
+	dw.Add("b", types.MetricSinkSubmitResponse{Accepted: 3})
+	if response, ok := dw.Get("a"); !ok || response.Accepted != 1 {
+		t.Fatalf("expected a to stay in the window, was: %+v %v", response, ok)
+	}
+
+	dw.Add("c", types.MetricSinkSubmitResponse{Accepted: 4})
+	if _, ok := dw.Get("a"); ok {
+		t.Fatalf("expected the oldest key to be evicted once the window is full")
+	}
+	for key, accepted := range map[string]int{"b": 3, "c": 4} {
+		if response, ok := dw.Get(key); !ok || response.Accepted != accepted {
+			t.Fatalf("expected %s to stay in the window, was: %+v %v", key, response, ok)
+		}
+	}
+
+	dw.Add("", types.MetricSinkSubmitResponse{Accepted: 5})
+	if _, ok := dw.Get(""); ok {
+		t.Fatalf("expected an empty key never to be deduplicated")
+	}
+	if _, ok := dw.Get("b"); !ok {
+		t.Fatalf("expected an empty key not to evict another key")
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"gossip/pkg/types"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -15,6 +17,7 @@ import (
 var (
 	dataMu sync.Mutex
 	data   map[string]*metric
+	seen   *dedupWindow
 )
 
 func bindAddr() string {
@@ -24,8 +27,18 @@ func bindAddr() string {
 	return ":3000"
 }
 
+func dedupWindowSize() int {
+	if value := os.Getenv("DEDUP_WINDOW"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			return size
+		}
+	}
+	return 4096
+}
+
 func main() {
 	data = make(map[string]*metric)
+	seen = newDedupWindow(dedupWindowSize())
 	http.Handle("/", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		dataMu.Lock()
 		defer dataMu.Unlock()
@@ -33,22 +46,7 @@ func main() {
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(data)
 	}))
-	http.Handle("/submit", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		var submissionData types.MetricSinkSubmission
-		if err := json.NewDecoder(req.Body).Decode(&submissionData); err != nil {
-			http.Error(rw, "invalid post body: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		dataMu.Lock()
-		defer dataMu.Unlock()
-		for _, e := range submissionData.Values {
-			if _, ok := data[e.Entity]; !ok {
-				data[e.Entity] = new(metric)
-			}
-			data[e.Entity].Push(e)
-		}
-		rw.WriteHeader(http.StatusNoContent)
-	}))
+	http.Handle("/submit", http.HandlerFunc(submit))
 	server := &http.Server{
 		Addr:    bindAddr(),
 		Handler: http.DefaultServeMux,
@@ -64,16 +62,99 @@ func main() {
 	server.ListenAndServe()
 }
 
+// submit applies a submission's values that aren't fenced off, once per
+// idempotency key within the dedup window.
+func submit(rw http.ResponseWriter, req *http.Request) {
+	var submissionData types.MetricSinkSubmission
+	if err := json.NewDecoder(req.Body).Decode(&submissionData); err != nil {
+		http.Error(rw, "invalid post body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	if original, ok := seen.Get(submissionData.IdempotencyKey); ok {
+		original.Duplicate = true
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(original)
+		return
+	}
+	var response types.MetricSinkSubmitResponse
+	for _, e := range submissionData.Values {
+		if _, ok := data[e.Entity]; !ok {
+			data[e.Entity] = new(metric)
+		}
+		reason, conflict := data[e.Entity].Fence(e)
+		if reason != "" {
+			response.Rejected = append(response.Rejected, types.MetricSinkRejectedValue{Entity: e.Entity, Reason: reason})
+			continue
+		}
+		if conflict {
+			response.Conflicts = append(response.Conflicts, e.Entity)
+		}
+		data[e.Entity].Push(e)
+		response.Accepted++
+	}
+	seen.Add(submissionData.IdempotencyKey, response)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(response)
+}
+
 type metric struct {
 	Last    time.Duration
+	Min     time.Duration
+	Max     time.Duration
 	Count   uint64
 	Values  []time.Duration
 	Writers []string
+
+	// Writer and Epoch are the writer with the newest epoch for the entity.
+	Writer string
+	Epoch  types.Epoch
+	// Stale is the number of values rejected for an older epoch.
+	Stale uint64
+	// Conflicts is the number of values accepted from a different writer with the same ring.
+	Conflicts uint64
+}
+
+// Fence returns a reason to reject a value if a different writer has submitted
+// the entity with a newer epoch, and if the value indicates split ownership.
+//
+// Values from the current writer, and values without an epoch, are always accepted.
+func (m *metric) Fence(s types.MetricSinkSubmissionValue) (reason string, conflict bool) {
+	if s.Epoch.IsZero() || m.Writer == "" || m.Writer == s.Hostname {
+		return
+	}
+	if s.Epoch.Less(m.Epoch) {
+		m.Stale++
+		reason = fmt.Sprintf("stale epoch; %s holds a newer epoch", m.Writer)
+		return
+	}
+	if s.Epoch.Ring == m.Epoch.Ring {
+		m.Conflicts++
+		conflict = true
+	}
+	return
 }
 
 func (m *metric) Push(s types.MetricSinkSubmissionValue) {
+	if !s.Epoch.IsZero() && (m.Writer != s.Hostname || m.Epoch.Less(s.Epoch)) {
+		m.Writer = s.Hostname
+		m.Epoch = s.Epoch
+	}
+	aggregate := types.Aggregate{Min: s.Value, Max: s.Value, Last: s.Value, Count: 1}
+	if s.Aggregate != nil {
+		aggregate = *s.Aggregate
+	}
+	if m.Count == 0 || time.Duration(aggregate.Min) < m.Min {
+		m.Min = time.Duration(aggregate.Min)
+	}
+	if m.Count == 0 || time.Duration(aggregate.Max) > m.Max {
+		m.Max = time.Duration(aggregate.Max)
+	}
 	m.Last = time.Duration(s.Value)
-	m.Count++
+	m.Count += uint64(aggregate.Count)
 	m.Values = append(m.Values, time.Duration(s.Value))
 	m.Writers = append(m.Writers, s.Hostname)
 }
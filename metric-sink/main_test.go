@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"gossip/pkg/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_metric_Fence(t *testing.T) {
+	value := func(hostname string, view int64, ring uint64, v int64) types.MetricSinkSubmissionValue {
+		return types.MetricSinkSubmissionValue{Entity: "e", Hostname: hostname, Value: v, Epoch: types.Epoch{View: view, Incarnation: 1, Ring: ring}}
+	}
+	// submit fences and pushes a value like the submit handler, returning the rejection reason.
+	submit := func(m *metric, s types.MetricSinkSubmissionValue) (string, bool) {
+		reason, conflict := m.Fence(s)
+		if reason == "" {
+			m.Push(s)
+		}
+		return reason, conflict
+	}
+
+	m := new(metric)
+	if reason, conflict := submit(m, value("a", 10, 1, 1)); reason != "" || conflict {
+		t.Fatalf("expected the first writer to be accepted, was: %q %v", reason, conflict)
+	}
+	if reason, _ := submit(m, value("b", 20, 2, 2)); reason != "" || m.Writer != "b" {
+		t.Fatalf("expected a writer with a newer epoch to take over, was: %q %s", reason, m.Writer)
+	}
+	if reason, _ := submit(m, value("a", 10, 1, 3)); reason == "" || m.Stale != 1 || m.Last != 2 {
+		t.Fatalf("expected the stale writer to be rejected, was: %q %+v", reason, m)
+	}
+	if reason, _ := submit(m, value("b", 5, 1, 4)); reason != "" || m.Epoch.View != 20 || m.Last != 4 {
+		t.Fatalf("expected the current writer to always be accepted and keep its newest epoch, was: %q %+v", reason, m)
+	}
+
+	// a different writer with an equal epoch is accepted, and flagged as a
+	// conflict if it used the same ring.
+	if reason, conflict := submit(m, value("c", 20, 2, 5)); reason != "" || !conflict || m.Conflicts != 1 || m.Writer != "c" {
+		t.Fatalf("expected an equal epoch on the same ring to be a conflict, was: %q %v %+v", reason, conflict, m)
+	}
+	if reason, conflict := submit(m, value("b", 20, 3, 6)); reason != "" || conflict {
+		t.Fatalf("expected an equal epoch on a different ring to be accepted without conflict, was: %q %v", reason, conflict)
+	}
+
+	// values without an epoch are always accepted, and don't change the writer.
+	unfenced := value("d", 0, 0, 7)
+	unfenced.Epoch = types.Epoch{}
+	if reason, _ := submit(m, unfenced); reason != "" || m.Writer != "b" || m.Last != 7 {
+		t.Fatalf("expected a value without an epoch to be accepted, was: %q %+v", reason, m)
+	}
+}
+
+func Test_submit(t *testing.T) {
+	data = make(map[string]*metric)
+	seen = newDedupWindow(16)
+	post := func(s types.MetricSinkSubmission) types.MetricSinkSubmitResponse {
+		t.Helper()
+		body, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		submit(rec, httptest.NewRequest(http.MethodPost, "/submit", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, was: %d %s", rec.Code, rec.Body.String())
+		}
+		var response types.MetricSinkSubmitResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+		return response
+	}
+	epoch := types.Epoch{View: 10, Incarnation: 1, Ring: 1}
+	submission := types.MetricSinkSubmission{
+		IdempotencyKey: "a/1",
+		Values: []types.MetricSinkSubmissionValue{
+			{Entity: "x", Hostname: "a", Value: 1, Epoch: epoch},
+			{Entity: "y", Hostname: "a", Value: 2, Epoch: epoch},
+		},
+	}
+
+	if response := post(submission); response.Accepted != 2 || response.Duplicate {
+		t.Fatalf("expected both values to be accepted, was: %+v", response)
+	}
+	if response := post(submission); response.Accepted != 2 || !response.Duplicate {
+		t.Fatalf("expected a repeated key to return the original response, was: %+v", response)
+	}
+	if count := data["x"].Count; count != 1 {
+		t.Fatalf("expected a repeated key to be applied once, was: %d", count)
+	}
+
+	// a newer writer fences a; the response for a's key is still the original.
+	post(types.MetricSinkSubmission{
+		IdempotencyKey: "b/1",
+		Values:         []types.MetricSinkSubmissionValue{{Entity: "x", Hostname: "b", Value: 3, Epoch: types.Epoch{View: 20, Incarnation: 1, Ring: 2}}},
+	})
+	if response := post(submission); response.Accepted != 2 || len(response.Rejected) != 0 || !response.Duplicate {
+		t.Fatalf("expected the original response, was: %+v", response)
+	}
+	submission.IdempotencyKey = "a/2"
+	if response := post(submission); response.Accepted != 1 || len(response.Rejected) != 1 || response.Rejected[0].Entity != "x" {
+		t.Fatalf("expected the fenced value to be rejected, was: %+v", response)
+	}
+
+	submission.IdempotencyKey = ""
+	post(submission)
+	post(submission)
+	if count := data["y"].Count; count != 4 {
+		t.Fatalf("expected submissions without a key never to be deduplicated, was: %d", count)
+	}
+
+	rec := httptest.NewRecorder()
+	submit(rec, httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader("{")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid body to be rejected, was: %d", rec.Code)
+	}
+}
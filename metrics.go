@@ -0,0 +1,141 @@
+package main
+
+import (
+	"gossip/pkg/metrics"
+	"time"
+)
+
+// workerMetrics are the metrics the worker exposes on the admin server.
+type workerMetrics struct {
+	registry *metrics.Registry
+
+	tickDuration        *metrics.Histogram
+	tickLag             *metrics.Histogram
+	ticksSkipped        *metrics.Counter
+	entities            *metrics.Gauge
+	entitiesOwned       *metrics.Gauge
+	entitiesDue         *metrics.Gauge
+	entitiesTakenOver   *metrics.Gauge
+	fetches             *metrics.Counter
+	pushes              *metrics.Counter
+	pushValues          *metrics.Counter
+	pipelineDropped     *metrics.Counter
+	pipelineExecErrors  *metrics.Counter
+	userEvents          *metrics.Counter
+	queries             *metrics.Counter
+	kvMerged            *metrics.Counter
+	entityListRefreshes *metrics.Counter
+	entityListShares    *metrics.Counter
+	entityListReceived  *metrics.Counter
+	handoffs            *metrics.Counter
+	lastPushSuccess     *metrics.Gauge
+	upstreamDuration    *metrics.Histogram
+	joinAttempts        *metrics.Counter
+
+	upstreamCircuitState *metrics.Gauge
+	spoolReplayed        *metrics.Counter
+	pushRejected         *metrics.Counter
+	pushConflicts        *metrics.Counter
+	pushDuplicates       *metrics.Counter
+	sinkWrites           *metrics.Counter
+	sinkDuration         *metrics.Histogram
+	sinkDropped          *metrics.Counter
+}
+
+// newWorkerMetrics registers the worker metrics.
+func newWorkerMetrics(w *worker) *workerMetrics {
+	r := metrics.NewRegistry()
+	m := &workerMetrics{
+		registry:            r,
+		tickDuration:        r.Histogram("gossip_tick_duration_seconds", "The time taken to fetch and push entity data for a tick.", nil),
+		tickLag:             r.Histogram("gossip_tick_lag_seconds", "The delay between when a tick was scheduled and when it started.", nil),
+		ticksSkipped:        r.Counter("gossip_ticks_skipped_total", "The number of scheduled ticks skipped because the previous tick overran the interval."),
+		entities:            r.Gauge("gossip_entities", "The number of entities known to the worker."),
+		entitiesOwned:       r.Gauge("gossip_entities_owned", "The number of entities assigned to the worker."),
+		entitiesTakenOver:   r.Gauge("gossip_entities_taken_over", "The number of entities of stalled members the worker pushes as their second owner."),
+		entitiesDue:         r.Gauge("gossip_entities_due", "The number of entities due for polling in the last tick."),
+		fetches:             r.Counter("gossip_fetches_total", "The number of entity data fetches by result.", "result"),
+		pushes:              r.Counter("gossip_pushes_total", "The number of entity data pushes by result.", "result"),
+		pipelineDropped:     r.Counter("gossip_pipeline_dropped_total", "The number of fetched values the pipeline dropped, including values renamed to the same entity as another value."),
+		userEvents:          r.Counter("gossip_user_events_total", "The number of user events fired or received, by whether they were handled, unhandled, duplicate, stale or invalid.", "result"),
+		queries:             r.Counter("gossip_queries_received_total", "The number of queries received from other nodes, by whether they were answered, duplicate, stale or failed.", "result"),
+		kvMerged:            r.Counter("gossip_kv_merged_entries_total", "The number of shared state entries merged from other nodes, by whether they updated local state or were rejected.", "result"),
+		entityListRefreshes: r.Counter("gossip_entity_list_fetches_total", "The number of entity list fetches, by whether this node was the elected fetcher or fell back to fetching a stale list.", "result"),
+		entityListShares:    r.Counter("gossip_entity_list_shares_total", "The number of entity lists shared as the elected fetcher, by whether a diff was broadcast or the full list was sent.", "kind"),
+		entityListReceived:  r.Counter("gossip_entity_list_received_total", "The number of entity lists received from other nodes, by kind, including diffs missed for not matching the list held.", "kind"),
+		handoffs:            r.Counter("gossip_handoffs_total", "The number of entity state handoffs, by whether they were acknowledged, timed out or failed to send, or were received from other nodes.", "result"),
+		pipelineExecErrors:  r.Counter("gossip_pipeline_exec_errors_total", "The number of batches pipeline exec stages failed to handle, by command.", "command"),
+		pushValues:          r.Counter("gossip_push_values_total", "The number of fetched values by whether they were submitted, skipped as unchanged, or aggregated.", "result"),
+		lastPushSuccess:     r.Gauge("gossip_last_push_success_timestamp_seconds", "The unix time of the last successful push."),
+		upstreamDuration:    r.Histogram("gossip_upstream_request_duration_seconds", "The duration of upstream http requests by upstream and result.", nil, "upstream", "result"),
+		joinAttempts:        r.Counter("gossip_join_attempts_total", "The number of attempts to join the cluster by result.", "result"),
+
+		upstreamCircuitState: r.Gauge("gossip_upstream_circuit_state", "The upstream circuit breaker state; 0 is closed, 1 is open and 2 is half-open.", "upstream"),
+		spoolReplayed:        r.Counter("gossip_spool_replayed_total", "The number of spooled submissions replayed to the metric-sink."),
+		pushRejected:         r.Counter("gossip_push_rejected_total", "The number of values the metric-sink rejected for a stale ownership epoch."),
+		pushConflicts:        r.Counter("gossip_push_conflicts_total", "The number of values the metric-sink flagged as split ownership."),
+		pushDuplicates:       r.Counter("gossip_push_duplicates_total", "The number of submissions the metric-sink had already applied."),
+		sinkWrites:           r.Counter("gossip_sink_writes_total", "The number of writes to additional sinks by sink and result.", "sink", "result"),
+		sinkDuration:         r.Histogram("gossip_sink_write_duration_seconds", "The duration of writes to additional sinks, including retries, by sink.", nil, "sink"),
+		sinkDropped:          r.Counter("gossip_sink_dropped_total", "The number of submissions dropped because a sink's queue was full.", "sink"),
+	}
+	r.GaugeFunc("gossip_members", "The number of alive members in the cluster.", func() float64 {
+		if w.list == nil {
+			return 0
+		}
+		return float64(w.list.NumMembers())
+	})
+	r.GaugeFunc("gossip_memberlist_health_score", "The memberlist awareness health score; lower is healthier.", func() float64 {
+		if w.list == nil {
+			return 0
+		}
+		return float64(w.list.GetHealthScore())
+	})
+	r.GaugeFunc("gossip_tick_phase_seconds", "The offset of this node's ticks within the interval.", func() float64 {
+		return w.scheduler.Phase().Seconds()
+	})
+	r.GaugeFunc("gossip_push_paused", "Whether pushing is paused by a user event.", func() float64 {
+		if w.pushPaused(time.Now()) {
+			return 1
+		}
+		return 0
+	})
+	r.GaugeFunc("gossip_kv_keys", "The number of live shared state keys.", func() float64 {
+		live, _ := w.kv.Len()
+		return float64(live)
+	})
+	r.GaugeFunc("gossip_kv_tombstones", "The number of deleted shared state keys not yet collected.", func() float64 {
+		_, tombstones := w.kv.Len()
+		return float64(tombstones)
+	})
+	r.CounterFunc("gossip_cluster_entities_fetched_total", "The number of entities fetched by every worker in the cluster, as replicated over gossip.", func() float64 {
+		return float64(w.counters.Value(counterEntitiesFetched))
+	})
+	r.CounterFunc("gossip_cluster_values_pushed_total", "The number of values pushed by every worker in the cluster, as replicated over gossip.", func() float64 {
+		return float64(w.counters.Value(counterValuesPushed))
+	})
+	r.CounterFunc("gossip_cluster_push_failures_total", "The number of failed pushes by every worker in the cluster, as replicated over gossip.", func() float64 {
+		return float64(w.counters.Value(counterPushFailures))
+	})
+	r.GaugeFunc("gossip_spool_records", "The number of submissions waiting in the spool.", func() float64 {
+		return float64(w.spoolStats().Records)
+	})
+	r.GaugeFunc("gossip_spool_bytes", "The size of the spool on disk.", func() float64 {
+		return float64(w.spoolStats().Bytes)
+	})
+	r.CounterFunc("gossip_spool_dropped_total", "The number of spooled submissions dropped by the spool size and age caps.", func() float64 {
+		return float64(w.spoolStats().Dropped)
+	})
+	r.CounterFunc("gossip_spool_corrupt_total", "The number of spooled submissions skipped because they were corrupt.", func() float64 {
+		return float64(w.spoolStats().Corrupt)
+	})
+	return m
+}
+
+// result returns the result label value for an error.
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -76,7 +78,7 @@ type ConsistentHash struct {
 	replicas     int
 	hashFunction HashFunction
 	mu           sync.RWMutex
-	buckets      map[string]struct{}
+	buckets      map[string]int
 	hashring     []HashedBucket
 }
 
@@ -120,20 +122,33 @@ func (ch *ConsistentHash) AddBuckets(newBuckets ...string) (ok bool) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	if ch.buckets == nil {
-		ch.buckets = make(map[string]struct{})
-	}
 	for _, newBucket := range newBuckets {
-		if _, ok := ch.buckets[newBucket]; ok {
-			continue
+		if ch.addBucketUnsafe(newBucket, ch.Replicas()) {
+			ok = true
 		}
-		ok = true
-		ch.buckets[newBucket] = struct{}{}
-		ch.insertUnsafe(newBucket)
 	}
 	return
 }
 
+// AddWeightedBucket adds a bucket to the consistent hash with a given
+// number of virtual replicas, and returns a boolean indicating if the
+// bucket was added.
+//
+// Buckets with more replicas will be assigned proportionally more items;
+// replicas less than 1 are treated as 1.
+//
+// If the bucket already exists on the hash ring no action is taken.
+//
+// Calling `AddWeightedBucket` is safe to do concurrently
+// and acquires a write lock on the consistent hash reference.
+func (ch *ConsistentHash) AddWeightedBucket(newBucket string, replicas int) (ok bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ok = ch.addBucketUnsafe(newBucket, max(replicas, 1))
+	return
+}
+
 // RemoveBucket removes a bucket from the consistent hash, and returns
 // a boolean indicating if the provided bucket was found.
 //
@@ -153,11 +168,12 @@ func (ch *ConsistentHash) RemoveBucket(toRemove string) (ok bool) {
 	if _, ok = ch.buckets[toRemove]; !ok {
 		return
 	}
+	replicas := ch.buckets[toRemove]
 	// delete the bucket entry
 	delete(ch.buckets, toRemove)
 
 	// delete all the replicas from the hash ring for the bucket (there can be many!)
-	for x := 0; x < ch.Replicas(); x++ {
+	for x := 0; x < replicas; x++ {
 		index := ch.search(ch.bucketHashKey(toRemove, x))
 		// do slice things to pull it out of the ring.
 		ch.hashring = append(ch.hashring[:index], ch.hashring[index+1:]...)
@@ -225,6 +241,54 @@ func (ch *ConsistentHash) Assignments(items ...string) map[string][]string {
 	return output
 }
 
+// Owners returns up to `n` distinct buckets for a given item, in the order
+// they follow the item on the ring; the first is the item's assignment, and
+// each next bucket is the one the item would move to were the buckets before
+// it removed.
+//
+// Calling `Owners` is safe to do concurrently and acquires
+// a read lock on the consistent hash reference.
+func (ch *ConsistentHash) Owners(item string, n int) (buckets []string) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	if len(ch.hashring) == 0 {
+		return
+	}
+	index := ch.search(item)
+	for x := 0; x < len(ch.hashring) && len(buckets) < n; x++ {
+		bucket := ch.hashring[(index+x)%len(ch.hashring)].Bucket
+		if !slices.Contains(buckets, bucket) {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return
+}
+
+// Fingerprint returns a hash of the buckets and their replica counts.
+//
+// Two consistent hashes with the same hash function and the same fingerprint
+// will make the same assignments, so fingerprints can be compared across
+// nodes to detect disagreements about the ring.
+//
+// Calling `Fingerprint` is safe to do concurrently and acquires
+// a read lock on the consistent hash reference.
+func (ch *ConsistentHash) Fingerprint() uint64 {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	buckets := make([]string, 0, len(ch.buckets))
+	for bucket := range ch.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	hash := fnv.New64a()
+	for _, bucket := range buckets {
+		fmt.Fprintf(hash, "%s|%d\n", bucket, ch.buckets[bucket])
+	}
+	return hash.Sum64()
+}
+
 // String returns a string form of the hash for debugging purposes.
 //
 // Calling `String` is safe to do concurrently and acquires
@@ -266,7 +330,12 @@ func (ch *ConsistentHash) MarshalJSON() ([]byte, error) {
 // assignmentUnsafe searches for the item's matching bucket based
 // on a binary search, and if the index returned is outside the
 // ring length, the first index (0) is returned to simulate wrapping around.
+//
+// If the ring is empty, an empty bucket is returned.
 func (ch *ConsistentHash) assignmentUnsafe(item string) (bucket string) {
+	if len(ch.hashring) == 0 {
+		return
+	}
 	index := ch.search(item)
 	if index >= len(ch.hashring) {
 		index = 0
@@ -275,15 +344,29 @@ func (ch *ConsistentHash) assignmentUnsafe(item string) (bucket string) {
 	return
 }
 
+// addBucketUnsafe records a bucket and inserts its replicas
+// if the bucket does not already exist.
+func (ch *ConsistentHash) addBucketUnsafe(bucket string, replicas int) bool {
+	if ch.buckets == nil {
+		ch.buckets = make(map[string]int)
+	}
+	if _, ok := ch.buckets[bucket]; ok {
+		return false
+	}
+	ch.buckets[bucket] = replicas
+	ch.insertUnsafe(bucket, replicas)
+	return true
+}
+
 // insert inserts a hashring bucket.
 //
 // insert uses an insertion sort such that the
 // resulting ring will remain sorted after insert.
 //
-// it will also insert `ReplicasOrDefault` copies of the bucket
+// it will also insert `replicas` copies of the bucket
 // to help distribute items across buckets more evenly.
-func (ch *ConsistentHash) insertUnsafe(bucket string) {
-	for x := 0; x < ch.Replicas(); x++ {
+func (ch *ConsistentHash) insertUnsafe(bucket string, replicas int) {
+	for x := 0; x < replicas; x++ {
 		ch.insertionSort(HashedBucket{
 			Hashcode: ch.hashcode(ch.bucketHashKey(bucket, x)),
 			Bucket:   bucket,
@@ -0,0 +1,37 @@
+package consistenthash
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ConsistentHash_Owners(t *testing.T) {
+	ch := New()
+	if owners := ch.Owners("item", 2); len(owners) != 0 {
+		t.Fatalf("expected an empty ring to have no owners, was: %v", owners)
+	}
+	ch.AddBuckets("a", "b", "c")
+	for x := 0; x < 100; x++ {
+		item := fmt.Sprintf("item-%d", x)
+		owners := ch.Owners(item, 2)
+		if len(owners) != 2 || owners[0] == owners[1] {
+			t.Fatalf("expected two distinct owners for %s, was: %v", item, owners)
+		}
+		if owners[0] != ch.Assignment(item) {
+			t.Fatalf("expected the first owner of %s to be its assignment, was: %v", item, owners)
+		}
+
+		without := New()
+		for _, bucket := range ch.Buckets() {
+			if bucket != owners[0] {
+				without.AddBuckets(bucket)
+			}
+		}
+		if assignment := without.Assignment(item); assignment != owners[1] {
+			t.Fatalf("expected %s to move to its second owner %s, was: %s", item, owners[1], assignment)
+		}
+	}
+	if owners := ch.Owners("item", 5); len(owners) != 3 {
+		t.Fatalf("expected at most every bucket, was: %v", owners)
+	}
+}
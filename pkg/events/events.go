@@ -0,0 +1,255 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// DefaultBufferSize is the default number of lamport times events are remembered
+	// for, to drop duplicates; events older than that are dropped as stale.
+	DefaultBufferSize = 512
+	// DefaultRetransmitMult is the default multiplier of the number of
+	// times an event is retransmitted, scaled by the log of the cluster size.
+	DefaultRetransmitMult = 4
+	// MaxPayloadSize is the maximum size of an event payload, such that
+	// events fit in a gossip packet alongside memberlist's own messages.
+	MaxPayloadSize = 512
+	// MaxNameLength is the maximum length of an event name.
+	MaxNameLength = 64
+)
+
+var (
+	// ErrDuplicate is returned by `Receive` for events that have already been received.
+	ErrDuplicate = errors.New("events: duplicate event")
+	// ErrStale is returned by `Receive` for events too old to tell if they're duplicates.
+	ErrStale = errors.New("events: stale event")
+)
+
+// Event is a named user event, broadcast to every node in the cluster.
+type Event struct {
+	Name    string `json:"name"`
+	Payload []byte `json:"payload,omitempty"`
+	// LTime is the lamport time the event was fired at.
+	LTime uint64 `json:"ltime"`
+	// Origin is the name of the node that fired the event.
+	Origin string `json:"origin"`
+}
+
+// Handler handles an event; handlers are called from memberlist's
+// packet handler and must not block.
+type Handler func(Event)
+
+// Clock is a lamport clock.
+type Clock struct {
+	counter atomic.Uint64
+}
+
+// Time returns the current time.
+func (c *Clock) Time() uint64 {
+	return c.counter.Load()
+}
+
+// Increment increments the clock and returns the new time.
+func (c *Clock) Increment() uint64 {
+	return c.counter.Add(1)
+}
+
+// Witness advances the clock past a time seen in another node's message.
+func (c *Clock) Witness(t uint64) {
+	for {
+		current := c.counter.Load()
+		if t < current {
+			return
+		}
+		if c.counter.CompareAndSwap(current, t+1) {
+			return
+		}
+	}
+}
+
+// Options configure a `Bus`.
+type Options struct {
+	// NumNodes returns the number of nodes in the cluster.
+	NumNodes       func() int
+	RetransmitMult int
+	BufferSize     int
+	// Prefix is prepended to every broadcast, e.g. a message type byte.
+	Prefix []byte
+}
+
+// RetransmitMultOrDefault returns the retransmit multiplier or a default.
+func (o Options) RetransmitMultOrDefault() int {
+	if o.RetransmitMult > 0 {
+		return o.RetransmitMult
+	}
+	return DefaultRetransmitMult
+}
+
+// BufferSizeOrDefault returns the buffer size or a default.
+func (o Options) BufferSizeOrDefault() int {
+	if o.BufferSize > 0 {
+		return o.BufferSize
+	}
+	return DefaultBufferSize
+}
+
+// Bus fires events, and receives, deduplicates, handles and rebroadcasts
+// events fired on other nodes, much like serf's user events.
+//
+// Calling methods on `Bus` is safe to do concurrently.
+type Bus struct {
+	origin string
+	prefix []byte
+	clock  Clock
+	queue  *memberlist.TransmitLimitedQueue
+
+	mu       sync.Mutex
+	buffer   []*slot
+	handlers map[string][]Handler
+}
+
+// slot holds the events seen at a lamport time.
+type slot struct {
+	ltime  uint64
+	events []Event
+}
+
+// New returns a new bus for events fired by a given node.
+func New(origin string, opts Options) *Bus {
+	numNodes := opts.NumNodes
+	if numNodes == nil {
+		numNodes = func() int { return 1 }
+	}
+	return &Bus{
+		origin: origin,
+		prefix: opts.Prefix,
+		queue: &memberlist.TransmitLimitedQueue{
+			NumNodes:       numNodes,
+			RetransmitMult: opts.RetransmitMultOrDefault(),
+		},
+		buffer:   make([]*slot, opts.BufferSizeOrDefault()),
+		handlers: make(map[string][]Handler),
+	}
+}
+
+// Handle registers a handler for events with a given name.
+func (b *Bus) Handle(name string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[name] = append(b.handlers[name], h)
+}
+
+// Fire handles an event locally and broadcasts it to the cluster.
+func (b *Bus) Fire(name string, payload []byte) (Event, error) {
+	if name == "" || len(name) > MaxNameLength {
+		return Event{}, fmt.Errorf("events: name must be 1 to %d bytes", MaxNameLength)
+	}
+	if len(payload) > MaxPayloadSize {
+		return Event{}, fmt.Errorf("events: payload too large; %d > %d", len(payload), MaxPayloadSize)
+	}
+	e := Event{Name: name, Payload: payload, LTime: b.clock.Increment(), Origin: b.origin}
+	if err := b.accept(e); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// Receive decodes an event broadcast by another node, without the prefix,
+// and handles and rebroadcasts it unless it has been received before.
+func (b *Bus) Receive(msg []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return Event{}, fmt.Errorf("events: %w", err)
+	}
+	b.clock.Witness(e.LTime)
+	return e, b.accept(e)
+}
+
+// accept records, handles and broadcasts an event.
+func (b *Bus) accept(e Event) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	size := uint64(len(b.buffer))
+	if now := b.clock.Time(); now > size && e.LTime < now-size {
+		b.mu.Unlock()
+		return ErrStale
+	}
+	index := e.LTime % size
+	s := b.buffer[index]
+	if s == nil || s.ltime != e.LTime {
+		s = &slot{ltime: e.LTime}
+		b.buffer[index] = s
+	}
+	for _, seen := range s.events {
+		if seen.Name == e.Name && seen.Origin == e.Origin && string(seen.Payload) == string(e.Payload) {
+			b.mu.Unlock()
+			return ErrDuplicate
+		}
+	}
+	s.events = append(s.events, e)
+	handlers := b.handlers[e.Name]
+	b.mu.Unlock()
+
+	b.queue.QueueBroadcast(&broadcast{msg: append(append([]byte(nil), b.prefix...), msg...)})
+	for _, h := range handlers {
+		h(e)
+	}
+	return nil
+}
+
+// GetBroadcasts returns queued broadcasts up to a byte limit, as
+// `memberlist.Delegate.GetBroadcasts` does.
+func (b *Bus) GetBroadcasts(overhead, limit int) [][]byte {
+	return b.queue.GetBroadcasts(overhead, limit)
+}
+
+// Queued returns the number of broadcasts still to be transmitted.
+func (b *Bus) Queued() int {
+	return b.queue.NumQueued()
+}
+
+// Time returns the current lamport time.
+func (b *Bus) Time() uint64 {
+	return b.clock.Time()
+}
+
+// Recent returns the events in the buffer, oldest first.
+func (b *Bus) Recent() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []Event
+	for _, s := range b.buffer {
+		if s != nil {
+			events = append(events, s.events...)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].LTime < events[j].LTime })
+	return events
+}
+
+// Handled returns if any handler is registered for events with a given name.
+func (b *Bus) Handled(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.handlers[name]) > 0
+}
+
+// broadcast is a queued event broadcast.
+type broadcast struct {
+	msg []byte
+}
+
+func (bc *broadcast) Invalidates(memberlist.Broadcast) bool { return false }
+func (bc *broadcast) Message() []byte                       { return bc.msg }
+func (bc *broadcast) Finished()                             {}
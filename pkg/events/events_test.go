@@ -0,0 +1,87 @@
+package events
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_Clock(t *testing.T) {
+	var c Clock
+	if c.Increment() != 1 {
+		t.Fatalf("expected time 1, was: %d", c.Time())
+	}
+	c.Witness(10)
+	if c.Time() != 11 {
+		t.Fatalf("expected time 11 after witnessing 10, was: %d", c.Time())
+	}
+	c.Witness(5)
+	if c.Time() != 11 {
+		t.Fatalf("expected witnessing an older time to be a no-op, was: %d", c.Time())
+	}
+}
+
+func Test_Bus(t *testing.T) {
+	prefix := []byte{7}
+	a := New("a", Options{Prefix: prefix})
+	b := New("b", Options{Prefix: prefix})
+	var handled []Event
+	b.Handle("refresh", func(e Event) { handled = append(handled, e) })
+
+	fired, err := a.Fire("refresh", []byte("now"))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	msgs := a.GetBroadcasts(0, 1400)
+	if len(msgs) != 1 || !bytes.HasPrefix(msgs[0], prefix) {
+		t.Fatalf("expected one prefixed broadcast, got: %q", msgs)
+	}
+
+	received, err := b.Receive(msgs[0][len(prefix):])
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if received.Origin != "a" || received.LTime != fired.LTime || string(received.Payload) != "now" {
+		t.Fatalf("unexpected event: %+v", received)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("expected the event to be handled once, was: %d", len(handled))
+	}
+	if b.Time() <= fired.LTime {
+		t.Fatalf("expected the clock to witness the event, was: %d", b.Time())
+	}
+	if b.Queued() != 1 {
+		t.Fatalf("expected the event to be rebroadcast")
+	}
+
+	if _, err := b.Receive(msgs[0][len(prefix):]); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected a duplicate, was: %v", err)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("expected the duplicate not to be handled, was: %d", len(handled))
+	}
+	if recent := b.Recent(); len(recent) != 1 || recent[0].Name != "refresh" {
+		t.Fatalf("unexpected recent events: %+v", recent)
+	}
+}
+
+func Test_Bus_stale(t *testing.T) {
+	b := New("b", Options{BufferSize: 4})
+	b.clock.Witness(100)
+	if _, err := b.Receive([]byte(`{"name":"refresh","ltime":50,"origin":"a"}`)); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected a stale event, was: %v", err)
+	}
+	if _, err := b.Receive([]byte(`{"name":"refresh","ltime":99,"origin":"a"}`)); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+}
+
+func Test_Bus_Fire_validation(t *testing.T) {
+	b := New("b", Options{})
+	if _, err := b.Fire("", nil); err == nil {
+		t.Fatalf("expected err to be set for an empty name")
+	}
+	if _, err := b.Fire("big", make([]byte, MaxPayloadSize+1)); err == nil {
+		t.Fatalf("expected err to be set for a large payload")
+	}
+}
@@ -0,0 +1,310 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// DefaultQueryTimeout is the default time a query waits for responses.
+const DefaultQueryTimeout = 2 * time.Second
+
+// ErrUnknownQuery is returned by `ReceiveResponse` for responses to queries
+// this node isn't waiting on, e.g. because the query timed out.
+var ErrUnknownQuery = errors.New("events: unknown query")
+
+// Query is a named request broadcast to the cluster, which the
+// nodes answer by sending a response directly to the origin.
+type Query struct {
+	ID      uint64 `json:"id"`
+	Name    string `json:"name"`
+	Payload []byte `json:"payload,omitempty"`
+	LTime   uint64 `json:"ltime"`
+	Origin  string `json:"origin"`
+	// Nodes, if set, are the only nodes that respond.
+	Nodes   []string      `json:"nodes,omitempty"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// Response is a node's response to a query.
+type Response struct {
+	ID      uint64 `json:"id"`
+	LTime   uint64 `json:"ltime"`
+	From    string `json:"from"`
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// QueryHandler answers a query; handlers are called from memberlist's
+// packet handler and must not block.
+type QueryHandler func(Query) ([]byte, error)
+
+// QueryParams are the parameters of a query.
+type QueryParams struct {
+	// Nodes, if set, are the only nodes that respond.
+	Nodes   []string
+	Timeout time.Duration
+}
+
+// TimeoutOrDefault returns the timeout or a default.
+func (qp QueryParams) TimeoutOrDefault() time.Duration {
+	if qp.Timeout > 0 {
+		return qp.Timeout
+	}
+	return DefaultQueryTimeout
+}
+
+// QueryOptions configure `Queries`.
+type QueryOptions struct {
+	Options
+	// ResponsePrefix is prepended to every response.
+	ResponsePrefix []byte
+	// Respond sends a response directly to the node with a given name.
+	Respond func(to string, msg []byte) error
+}
+
+// Queries sends queries and collects their responses, and receives,
+// deduplicates, answers and rebroadcasts queries sent by other nodes,
+// much like serf's queries.
+//
+// Calling methods on `Queries` is safe to do concurrently.
+type Queries struct {
+	origin string
+	opts   QueryOptions
+	clock  Clock
+	queue  *memberlist.TransmitLimitedQueue
+
+	mu       sync.Mutex
+	buffer   []*querySlot
+	handlers map[string]QueryHandler
+	pending  map[uint64]*pendingQuery
+}
+
+// querySlot holds the queries seen at a lamport time.
+type querySlot struct {
+	ltime uint64
+	seen  []queryKey
+}
+
+type queryKey struct {
+	id     uint64
+	origin string
+}
+
+// pendingQuery collects the responses to a query sent by this node.
+type pendingQuery struct {
+	ltime     uint64
+	responses []Response
+	updated   chan struct{}
+}
+
+// NewQueries returns new queries for a given node.
+func NewQueries(origin string, opts QueryOptions) *Queries {
+	numNodes := opts.NumNodes
+	if numNodes == nil {
+		numNodes = func() int { return 1 }
+	}
+	opts.NumNodes = numNodes
+	return &Queries{
+		origin: origin,
+		opts:   opts,
+		queue: &memberlist.TransmitLimitedQueue{
+			NumNodes:       numNodes,
+			RetransmitMult: opts.RetransmitMultOrDefault(),
+		},
+		buffer:   make([]*querySlot, opts.BufferSizeOrDefault()),
+		handlers: make(map[string]QueryHandler),
+		pending:  make(map[uint64]*pendingQuery),
+	}
+}
+
+// Handle registers the handler for queries with a given name.
+func (qs *Queries) Handle(name string, h QueryHandler) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.handlers[name] = h
+}
+
+// Ask broadcasts a query, including to this node, and returns the responses
+// sorted by node once every expected node responded or the timeout elapsed.
+//
+// The expected nodes are the nodes in the parameters, if set, and
+// otherwise the number of nodes in the cluster.
+func (qs *Queries) Ask(ctx context.Context, name string, payload []byte, params QueryParams) ([]Response, error) {
+	if name == "" || len(name) > MaxNameLength {
+		return nil, fmt.Errorf("events: name must be 1 to %d bytes", MaxNameLength)
+	}
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("events: payload too large; %d > %d", len(payload), MaxPayloadSize)
+	}
+	q := Query{
+		ID:      rand.Uint64(),
+		Name:    name,
+		Payload: payload,
+		LTime:   qs.clock.Increment(),
+		Origin:  qs.origin,
+		Nodes:   params.Nodes,
+		Timeout: params.TimeoutOrDefault(),
+	}
+	expected := len(params.Nodes)
+	if expected == 0 {
+		expected = qs.opts.NumNodes()
+	}
+
+	p := &pendingQuery{ltime: q.LTime, updated: make(chan struct{}, 1)}
+	qs.mu.Lock()
+	qs.pending[q.ID] = p
+	qs.mu.Unlock()
+	defer func() {
+		qs.mu.Lock()
+		delete(qs.pending, q.ID)
+		qs.mu.Unlock()
+	}()
+	if err := qs.accept(q); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(q.Timeout)
+	defer timer.Stop()
+wait:
+	for {
+		qs.mu.Lock()
+		done := len(p.responses) >= expected
+		qs.mu.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-p.updated:
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	responses := append([]Response(nil), p.responses...)
+	slices.SortFunc(responses, func(i, j Response) int {
+		return strings.Compare(i.From, j.From)
+	})
+	return responses, ctx.Err()
+}
+
+// Receive decodes a query sent by another node, without the prefix,
+// and answers and rebroadcasts it unless it has been received before.
+func (qs *Queries) Receive(msg []byte) (Query, error) {
+	var q Query
+	if err := json.Unmarshal(msg, &q); err != nil {
+		return Query{}, fmt.Errorf("events: %w", err)
+	}
+	qs.clock.Witness(q.LTime)
+	return q, qs.accept(q)
+}
+
+// ReceiveResponse decodes a response to a query sent by this node, without the prefix.
+func (qs *Queries) ReceiveResponse(msg []byte) (Response, error) {
+	var r Response
+	if err := json.Unmarshal(msg, &r); err != nil {
+		return Response{}, fmt.Errorf("events: %w", err)
+	}
+	return r, qs.deliver(r)
+}
+
+// accept records, rebroadcasts and answers a query.
+func (qs *Queries) accept(q Query) error {
+	msg, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+
+	qs.mu.Lock()
+	size := uint64(len(qs.buffer))
+	if now := qs.clock.Time(); now > size && q.LTime < now-size {
+		qs.mu.Unlock()
+		return ErrStale
+	}
+	index := q.LTime % size
+	s := qs.buffer[index]
+	if s == nil || s.ltime != q.LTime {
+		s = &querySlot{ltime: q.LTime}
+		qs.buffer[index] = s
+	}
+	key := queryKey{id: q.ID, origin: q.Origin}
+	if slices.Contains(s.seen, key) {
+		qs.mu.Unlock()
+		return ErrDuplicate
+	}
+	s.seen = append(s.seen, key)
+	h := qs.handlers[q.Name]
+	qs.mu.Unlock()
+
+	qs.queue.QueueBroadcast(&broadcast{msg: append(append([]byte(nil), qs.opts.Prefix...), msg...)})
+	if len(q.Nodes) > 0 && !slices.Contains(q.Nodes, qs.origin) {
+		return nil
+	}
+	r := Response{ID: q.ID, LTime: q.LTime, From: qs.origin}
+	if h == nil {
+		r.Error = fmt.Sprintf("no handler for %q", q.Name)
+	} else if r.Payload, err = h(q); err != nil {
+		r.Error = err.Error()
+	}
+	return qs.respond(q.Origin, r)
+}
+
+// respond sends a response to the node that sent the query.
+func (qs *Queries) respond(to string, r Response) error {
+	if to == qs.origin {
+		return qs.deliver(r)
+	}
+	if qs.opts.Respond == nil {
+		return nil
+	}
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return qs.opts.Respond(to, append(append([]byte(nil), qs.opts.ResponsePrefix...), msg...))
+}
+
+// deliver adds a response to its pending query, ignoring
+// repeated responses from the same node.
+func (qs *Queries) deliver(r Response) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	p, ok := qs.pending[r.ID]
+	if !ok || p.ltime != r.LTime {
+		return ErrUnknownQuery
+	}
+	for _, seen := range p.responses {
+		if seen.From == r.From {
+			return ErrDuplicate
+		}
+	}
+	p.responses = append(p.responses, r)
+	select {
+	case p.updated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// GetBroadcasts returns queued broadcasts up to a byte limit, as
+// `memberlist.Delegate.GetBroadcasts` does.
+func (qs *Queries) GetBroadcasts(overhead, limit int) [][]byte {
+	return qs.queue.GetBroadcasts(overhead, limit)
+}
+
+// Time returns the current lamport time.
+func (qs *Queries) Time() uint64 {
+	return qs.clock.Time()
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Queries_Ask(t *testing.T) {
+	var a, b *Queries
+	opts := func() QueryOptions {
+		return QueryOptions{
+			Options:        Options{NumNodes: func() int { return 2 }, Prefix: []byte{1}},
+			ResponsePrefix: []byte{2},
+			Respond: func(to string, msg []byte) error {
+				if to != "a" {
+					return fmt.Errorf("unexpected response to %q", to)
+				}
+				_, err := a.ReceiveResponse(msg[1:])
+				return err
+			},
+		}
+	}
+	a = NewQueries("a", opts())
+	b = NewQueries("b", opts())
+	for name, qs := range map[string]*Queries{"a": a, "b": b} {
+		qs.Handle("whoami", func(q Query) ([]byte, error) {
+			return []byte(name + ":" + string(q.Payload)), nil
+		})
+	}
+
+	type result struct {
+		responses []Response
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		responses, err := a.Ask(context.Background(), "whoami", []byte("x"), QueryParams{Timeout: 5 * time.Second})
+		done <- result{responses, err}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	var msgs [][]byte
+	for len(msgs) == 0 && time.Now().Before(deadline) {
+		msgs = a.GetBroadcasts(0, 1400)
+		time.Sleep(time.Millisecond)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected one query broadcast, got: %q", msgs)
+	}
+	if _, err := b.Receive(msgs[0][1:]); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if _, err := b.Receive(msgs[0][1:]); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected a duplicate, was: %v", err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("expected err to be unset, was: %v", r.err)
+		}
+		if len(r.responses) != 2 || string(r.responses[0].Payload) != "a:x" || string(r.responses[1].Payload) != "b:x" {
+			t.Fatalf("unexpected responses: %+v", r.responses)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the query to complete once both nodes responded")
+	}
+
+	if _, err := a.ReceiveResponse([]byte(`{"id":1,"from":"c"}`)); !errors.Is(err, ErrUnknownQuery) {
+		t.Fatalf("expected an unknown query, was: %v", err)
+	}
+}
+
+func Test_Queries_Ask_filtered(t *testing.T) {
+	testCases := []struct {
+		name     string
+		nodes    []string
+		handled  bool
+		response string
+		errText  string
+	}{
+		{
+			name:     "handled",
+			handled:  true,
+			response: "ok",
+		},
+		{
+			name:    "unhandled",
+			errText: `no handler for "ping"`,
+		},
+		{
+			name:    "filtered out",
+			nodes:   []string{"b"},
+			handled: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewQueries("a", QueryOptions{})
+			if tc.handled {
+				a.Handle("ping", func(Query) ([]byte, error) { return []byte("ok"), nil })
+			}
+			responses, err := a.Ask(context.Background(), "ping", nil, QueryParams{Nodes: tc.nodes, Timeout: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("expected err to be unset, was: %v", err)
+			}
+			if tc.response == "" && tc.errText == "" {
+				if len(responses) != 0 {
+					t.Fatalf("expected no responses, got: %+v", responses)
+				}
+				return
+			}
+			if len(responses) != 1 || string(responses[0].Payload) != tc.response || responses[0].Error != tc.errText {
+				t.Fatalf("unexpected responses: %+v", responses)
+			}
+		})
+	}
+}
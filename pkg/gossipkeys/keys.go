@@ -0,0 +1,105 @@
+package gossipkeys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Keys is a parsed set of gossip encryption keys.
+type Keys struct {
+	// Primary is the key used to encrypt outgoing messages.
+	Primary []byte
+	// All are all the keys that can be used to decrypt incoming
+	// messages, including the primary key.
+	All [][]byte
+}
+
+// Contains returns if a given key is in the set.
+func (k Keys) Contains(key []byte) bool {
+	for _, existing := range k.All {
+		if bytes.Equal(existing, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads keys from a file.
+//
+// See `Parse` for the file format.
+func Load(path string) (Keys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Keys{}, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads keys, one base64 encoded key per line, where the first key is
+// the primary key. Blank lines and lines starting with `#` are ignored.
+//
+// This matches the layout of a Kubernetes secret mounted as a file where
+// the secret value is a newline separated list of keys.
+func Parse(r io.Reader) (output Keys, err error) {
+	scanner := bufio.NewScanner(r)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var key []byte
+		key, err = Decode(text)
+		if err != nil {
+			err = fmt.Errorf("gossipkeys: line %d: %w", line, err)
+			return
+		}
+		if output.Contains(key) {
+			continue
+		}
+		if output.Primary == nil {
+			output.Primary = key
+		}
+		output.All = append(output.All, key)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if output.Primary == nil {
+		err = errors.New("gossipkeys: no keys found")
+	}
+	return
+}
+
+// Decode decodes and validates a single base64 encoded key.
+//
+// Keys must be 16, 24 or 32 bytes to select AES-128, AES-192, or AES-256.
+func Decode(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid key length %d; must be 16, 24 or 32 bytes", len(key))
+	}
+}
+
+// Fingerprint returns a short, non-reversible identifier for a key
+// suitable for logging and debugging.
+func Fingerprint(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:6])
+}
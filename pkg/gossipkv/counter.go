@@ -0,0 +1,334 @@
+package gossipkv
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRetireAfter is the default time a process must be dead
+// before its counter slots are folded into a live process's slot.
+const DefaultRetireAfter = 10 * time.Minute
+
+// GCounter is a grow-only counter with a slot per node, which only its node
+// increments; replicas converge by taking the maximum of every slot.
+type GCounter map[string]uint64
+
+// Value returns the sum of all slots.
+func (c GCounter) Value() (total uint64) {
+	for _, n := range c {
+		total += n
+	}
+	return
+}
+
+// Merge merges another counter, returning if any slot changed.
+func (c GCounter) Merge(other GCounter) (changed bool) {
+	for slot, n := range other {
+		if n > c[slot] {
+			c[slot] = n
+			changed = true
+		}
+	}
+	return
+}
+
+// PNCounter is a counter that can also be decremented, made of a counter
+// of increments and a counter of decrements.
+type PNCounter struct {
+	P GCounter `json:"p"`
+	N GCounter `json:"n,omitempty"`
+	// Retired are the slots folded into another slot, by the unix time in
+	// milliseconds they were folded at, which are dropped from merged state
+	// such that their values aren't counted twice.
+	Retired map[string]int64 `json:"retired,omitempty"`
+}
+
+// NewPNCounter returns a counter at zero.
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: make(GCounter), N: make(GCounter), Retired: make(map[string]int64)}
+}
+
+// Add adds a delta to a slot.
+func (c *PNCounter) Add(slot string, delta int64) {
+	if delta >= 0 {
+		c.P[slot] += uint64(delta)
+	} else {
+		c.N[slot] += uint64(-delta)
+	}
+}
+
+// Value returns the increments less the decrements.
+func (c *PNCounter) Value() int64 {
+	return int64(c.P.Value() - c.N.Value())
+}
+
+// Slots returns the value of each slot.
+func (c *PNCounter) Slots() map[string]int64 {
+	output := make(map[string]int64, len(c.P))
+	for slot, n := range c.P {
+		output[slot] += int64(n)
+	}
+	for slot, n := range c.N {
+		output[slot] -= int64(n)
+	}
+	return output
+}
+
+// Merge merges another counter, returning if any slot changed.
+func (c *PNCounter) Merge(other *PNCounter) bool {
+	for slot, at := range other.Retired {
+		c.Retired[slot] = max(c.Retired[slot], at)
+	}
+	p := c.P.Merge(other.P)
+	n := c.N.Merge(other.N)
+	for slot := range c.Retired {
+		delete(c.P, slot)
+		delete(c.N, slot)
+	}
+	return p || n
+}
+
+// fold adds the value of a slot to another slot, and retires it.
+func (c *PNCounter) fold(slot, into string, at time.Time) {
+	_, p := c.P[slot]
+	_, n := c.N[slot]
+	if !p && !n {
+		return
+	}
+	if n, ok := c.P[slot]; ok {
+		c.P[into] += n
+		delete(c.P, slot)
+	}
+	if n, ok := c.N[slot]; ok {
+		c.N[into] += n
+		delete(c.N, slot)
+	}
+	c.Retired[slot] = at.UnixMilli()
+}
+
+// CounterOptions configure `Counters`.
+type CounterOptions struct {
+	// Node is the name of this node, and Started is when this process
+	// started, which together make up the process's slot.
+	Node    string
+	Started time.Time
+	// RetireAfter is how long a process must be dead before its slots are folded.
+	RetireAfter time.Duration
+	// TombstoneTTL is how long folded slots are remembered for, which must
+	// exceed the time it takes a fold to reach every node.
+	TombstoneTTL time.Duration
+	// Now returns the current time; defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// RetireAfterOrDefault returns the time a process must be dead before its slots are folded, or a default.
+func (o CounterOptions) RetireAfterOrDefault() time.Duration {
+	if o.RetireAfter > 0 {
+		return o.RetireAfter
+	}
+	return DefaultRetireAfter
+}
+
+// TombstoneTTLOrDefault returns the tombstone ttl or a default.
+func (o CounterOptions) TombstoneTTLOrDefault() time.Duration {
+	if o.TombstoneTTL > 0 {
+		return o.TombstoneTTL
+	}
+	return DefaultTombstoneTTL
+}
+
+// NowOrDefault returns the current time.
+func (o CounterOptions) NowOrDefault() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// counterSlot returns the slot of a node's process started at a given time.
+func counterSlot(node string, started int64) string {
+	return fmt.Sprintf("%s/%d", node, started)
+}
+
+// parseCounterSlot returns the node and start time of a process's slot.
+func parseCounterSlot(slot string) (node string, started int64, ok bool) {
+	node, value, ok := strings.Cut(slot, "/")
+	if !ok {
+		return "", 0, false
+	}
+	started, err := strconv.ParseInt(value, 10, 64)
+	return node, started, err == nil
+}
+
+// Counters are named counters, which this node adds to in its own slot.
+//
+// The slot must be unique to a process, rather than to a node name, since
+// a restarted process starts its slots at zero again; the maximum of a
+// reused slot would hide increments until they exceed the old value.
+//
+// So that the slots of processes that restarted or left don't accumulate,
+// `Retire` folds them into a live process's slot once they have been dead
+// for a while; only the live node that sorts first folds, such that a
+// slot isn't folded twice while the nodes agree on the members.
+//
+// Calling methods on `Counters` is safe to do concurrently.
+type Counters struct {
+	opts CounterOptions
+
+	mu       sync.Mutex
+	slot     string
+	started  int64
+	counters map[string]*PNCounter
+	// deadSince is when every dead process's slot was first seen dead.
+	deadSince map[string]time.Time
+}
+
+// NewCounters returns counters that add to the slot of this process.
+func NewCounters(opts CounterOptions) *Counters {
+	started := opts.Started.UnixMilli()
+	return &Counters{
+		opts:      opts,
+		slot:      counterSlot(opts.Node, started),
+		started:   started,
+		counters:  make(map[string]*PNCounter),
+		deadSince: make(map[string]time.Time),
+	}
+}
+
+// Add adds a delta to a named counter.
+func (cs *Counters) Add(name string, delta int64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.counter(name).Add(cs.slot, delta)
+}
+
+// Value returns the cluster-wide value of a named counter.
+func (cs *Counters) Value(name string) int64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if c, ok := cs.counters[name]; ok {
+		return c.Value()
+	}
+	return 0
+}
+
+// Slots returns the value of each slot of every counter.
+func (cs *Counters) Slots() map[string]map[string]int64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	output := make(map[string]map[string]int64, len(cs.counters))
+	for name, c := range cs.counters {
+		output[name] = c.Slots()
+	}
+	return output
+}
+
+// State returns the encoded state of every counter.
+func (cs *Counters) State() ([]byte, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return json.Marshal(cs.counters)
+}
+
+// Merge merges encoded remote state, returning the number of counters that changed.
+func (cs *Counters) Merge(data []byte) (updated int, err error) {
+	var remote map[string]*PNCounter
+	if err := json.Unmarshal(data, &remote); err != nil {
+		return 0, fmt.Errorf("gossipkv: %w", err)
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	retired := false
+	for name, c := range remote {
+		if c == nil {
+			continue
+		}
+		local := cs.counter(name)
+		if local.Merge(c) {
+			updated++
+		}
+		_, ok := local.Retired[cs.slot]
+		retired = retired || ok
+	}
+	if retired {
+		// another node took this process for dead and folded its slot,
+		// which holds the values it has seen; count in a new slot from here.
+		cs.started = max(cs.opts.NowOrDefault().UnixMilli(), cs.started+1)
+		cs.slot = counterSlot(cs.opts.Node, cs.started)
+	}
+	return
+}
+
+// Retire folds the slots of processes that have been dead for the retire
+// period into this process's slot if this node is the first of the live
+// nodes, and forgets folded slots older than the tombstone ttl, returning
+// the number of slots folded.
+//
+// A process is dead once its node isn't live, or its node has a slot of a
+// process that started later.
+func (cs *Counters) Retire(live []string) (folded int) {
+	now := cs.opts.NowOrDefault()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	newest := make(map[string]int64)
+	for _, c := range cs.counters {
+		for _, g := range []GCounter{c.P, c.N} {
+			for slot := range g {
+				if node, started, ok := parseCounterSlot(slot); ok {
+					newest[node] = max(newest[node], started)
+				}
+			}
+		}
+	}
+	dead := make(map[string]time.Time)
+	for _, c := range cs.counters {
+		for _, g := range []GCounter{c.P, c.N} {
+			for slot := range g {
+				node, started, ok := parseCounterSlot(slot)
+				if !ok || slot == cs.slot || (slices.Contains(live, node) && started == newest[node]) {
+					continue
+				}
+				since, ok := cs.deadSince[slot]
+				if !ok {
+					since = now
+				}
+				dead[slot] = since
+			}
+		}
+	}
+	cs.deadSince = dead
+
+	fold := len(live) > 0 && slices.Min(live) == cs.opts.Node
+	for slot, since := range dead {
+		if !fold || now.Sub(since) < cs.opts.RetireAfterOrDefault() {
+			continue
+		}
+		for _, c := range cs.counters {
+			c.fold(slot, cs.slot, now)
+		}
+		delete(cs.deadSince, slot)
+		folded++
+	}
+	for _, c := range cs.counters {
+		for slot, at := range c.Retired {
+			if now.Sub(time.UnixMilli(at)) >= cs.opts.TombstoneTTLOrDefault() {
+				delete(c.Retired, slot)
+			}
+		}
+	}
+	return
+}
+
+func (cs *Counters) counter(name string) *PNCounter {
+	c, ok := cs.counters[name]
+	if !ok {
+		c = NewPNCounter()
+		cs.counters[name] = c
+	}
+	return c
+}
@@ -0,0 +1,137 @@
+package gossipkv
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// exchange merges the state of counters into other counters.
+func exchange(t *testing.T, from, to *Counters) {
+	t.Helper()
+	data, err := from.State()
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if _, err := to.Merge(data); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+}
+
+func Test_Counters_Merge(t *testing.T) {
+	a := NewCounters(CounterOptions{Node: "a", Started: time.UnixMilli(1)})
+	b := NewCounters(CounterOptions{Node: "b", Started: time.UnixMilli(1)})
+	c := NewCounters(CounterOptions{Node: "c", Started: time.UnixMilli(1)})
+	exchange := func(from, to *Counters) { exchange(t, from, to) }
+
+	a.Add("fetched", 10)
+	b.Add("fetched", 5)
+	exchange(a, b)
+
+	// a and b are partitioned from c while each keeps counting.
+	a.Add("fetched", 1)
+	b.Add("fetched", 2)
+	c.Add("fetched", 7)
+	c.Add("queued", 3)
+	c.Add("queued", -1)
+
+	exchange(c, b)
+	exchange(b, a)
+	exchange(a, c)
+	exchange(a, c)
+	for name, cs := range map[string]*Counters{"a": a, "c": c} {
+		if value := cs.Value("fetched"); value != 25 {
+			t.Fatalf("expected %s to converge to 25 fetched, was: %d", name, value)
+		}
+		if value := cs.Value("queued"); value != 2 {
+			t.Fatalf("expected %s to converge to 2 queued, was: %d", name, value)
+		}
+	}
+	if slots := a.Slots()["fetched"]; slots["a/1"] != 11 || slots["b/1"] != 7 || slots["c/1"] != 7 {
+		t.Fatalf("unexpected slots: %v", slots)
+	}
+	if value := b.Value("fetched"); value != 24 {
+		t.Fatalf("expected b to miss a's latest increment until it merges again, was: %d", value)
+	}
+
+	if _, err := a.Merge([]byte("nope")); err == nil {
+		t.Fatalf("expected err to be set for invalid state")
+	}
+}
+
+func Test_Counters_Retire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	opts := func(node string, started int64) CounterOptions {
+		return CounterOptions{Node: node, Started: time.UnixMilli(started), RetireAfter: time.Minute, TombstoneTTL: time.Hour, Now: func() time.Time { return now }}
+	}
+	a := NewCounters(opts("a", 1))
+	b := NewCounters(opts("b", 1))
+	stale := NewCounters(opts("c", 1))
+	a.Add("fetched", 10)
+	b.Add("fetched", 5)
+	b.Add("fetched", -1)
+	exchange(t, b, a)
+	exchange(t, b, stale)
+
+	// b restarts, and its previous process's slot is dead from then on.
+	restarted := NewCounters(opts("b", 2))
+	restarted.Add("fetched", 2)
+	exchange(t, restarted, a)
+	exchange(t, a, restarted)
+	live := []string{"a", "b"}
+	if folded := a.Retire(live); folded != 0 {
+		t.Fatalf("expected a dead slot not to be folded before the retire period, was: %d", folded)
+	}
+	now = now.Add(time.Minute)
+	if folded := restarted.Retire(live); folded != 0 {
+		t.Fatalf("expected only the first live node to fold, was: %d", folded)
+	}
+	if folded := a.Retire(live); folded != 1 {
+		t.Fatalf("expected the previous process's slot to be folded, was: %d", folded)
+	}
+	if slots := a.Slots()["fetched"]; len(slots) != 2 || slots["a/1"] != 14 || slots["b/2"] != 2 || a.Value("fetched") != 16 {
+		t.Fatalf("expected the dead slot to be folded into a's slot, was: %v", slots)
+	}
+
+	// a replica that still has the folded slot doesn't bring it back.
+	exchange(t, stale, a)
+	exchange(t, a, stale)
+	for name, cs := range map[string]*Counters{"a": a, "stale": stale} {
+		if value := cs.Value("fetched"); value != 16 {
+			t.Fatalf("expected %s not to count the folded slot twice, was: %d", name, value)
+		}
+		if _, ok := cs.Slots()["fetched"]["b/1"]; ok {
+			t.Fatalf("expected %s to drop the folded slot", name)
+		}
+	}
+
+	// b leaves, and is folded once it has been gone for the retire period.
+	live = []string{"a"}
+	a.Retire(live)
+	now = now.Add(time.Minute)
+	if folded := a.Retire(live); folded != 1 || len(a.Slots()["fetched"]) != 1 || a.Value("fetched") != 16 {
+		t.Fatalf("expected the slot of a node that left to be folded, was: %d %v", folded, a.Slots())
+	}
+
+	// a process taken for dead moves to a new slot, rather than counting in a folded one.
+	exchange(t, a, restarted)
+	restarted.Add("fetched", 3)
+	exchange(t, restarted, a)
+	if value := a.Value("fetched"); value != 19 {
+		t.Fatalf("expected increments after a fold to be counted once, was: %d", value)
+	}
+
+	now = now.Add(time.Hour)
+	a.Retire(live)
+	data, err := a.State()
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	var state map[string]*PNCounter
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if retired := state["fetched"].Retired; len(retired) != 0 {
+		t.Fatalf("expected folded slots to be forgotten after the tombstone ttl, was: %v", retired)
+	}
+}
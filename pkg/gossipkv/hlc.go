@@ -0,0 +1,82 @@
+package gossipkv
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock timestamp, ordered by wall time,
+// then by logical counter, then by node name to break ties between nodes.
+type Timestamp struct {
+	// Wall is the unix time in nanoseconds.
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical,omitempty"`
+	Node    string `json:"node"`
+}
+
+// Less returns if a timestamp is ordered before another.
+func (t Timestamp) Less(other Timestamp) bool {
+	if t.Wall != other.Wall {
+		return t.Wall < other.Wall
+	}
+	if t.Logical != other.Logical {
+		return t.Logical < other.Logical
+	}
+	return strings.Compare(t.Node, other.Node) < 0
+}
+
+// IsZero returns if the timestamp is unset.
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Time returns the wall time of the timestamp.
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, t.Wall)
+}
+
+// Clock is a hybrid logical clock, which follows physical time but never
+// goes backwards, and orders events after every timestamp it has witnessed.
+//
+// Calling methods on `Clock` is safe to do concurrently.
+type Clock struct {
+	node string
+	now  func() time.Time
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewClock returns a clock for a given node; if `now` is nil `time.Now` is used.
+func NewClock(node string, now func() time.Time) *Clock {
+	if now == nil {
+		now = time.Now
+	}
+	return &Clock{node: node, now: now}
+}
+
+// Now returns a timestamp ordered after every timestamp the clock returned or witnessed.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wall := c.now().UnixNano(); wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Node = c.node
+	return c.last
+}
+
+// Witness advances the clock past a timestamp from another node.
+func (c *Clock) Witness(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case t.Wall > c.last.Wall:
+		c.last.Wall, c.last.Logical = t.Wall, t.Logical
+	case t.Wall == c.last.Wall && t.Logical > c.last.Logical:
+		c.last.Logical = t.Logical
+	}
+}
@@ -0,0 +1,217 @@
+package gossipkv
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTombstoneTTL is the default time deleted keys are remembered
+	// for, which must exceed the time it takes a delete to reach every node.
+	DefaultTombstoneTTL = time.Hour
+	// DefaultMaxDrift is the default maximum time a remote timestamp may be
+	// ahead of the local clock, beyond which the entry is rejected.
+	DefaultMaxDrift = time.Minute
+	// MaxKeyLength is the maximum length of a key.
+	MaxKeyLength = 256
+	// MaxValueSize is the maximum size of a value.
+	MaxValueSize = 64 << 10
+)
+
+// Entry is the value of a key and the timestamp it was written at;
+// deleted keys are kept as tombstones until they're collected.
+type Entry struct {
+	Value     []byte    `json:"value,omitempty"`
+	Timestamp Timestamp `json:"timestamp"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// Options configure a `Map`.
+type Options struct {
+	// Node is the name of this node, which breaks ties between writes.
+	Node         string
+	TombstoneTTL time.Duration
+	MaxDrift     time.Duration
+	// Now returns the current time; defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// TombstoneTTLOrDefault returns the tombstone ttl or a default.
+func (o Options) TombstoneTTLOrDefault() time.Duration {
+	if o.TombstoneTTL > 0 {
+		return o.TombstoneTTL
+	}
+	return DefaultTombstoneTTL
+}
+
+// MaxDriftOrDefault returns the maximum drift or a default.
+func (o Options) MaxDriftOrDefault() time.Duration {
+	if o.MaxDrift > 0 {
+		return o.MaxDrift
+	}
+	return DefaultMaxDrift
+}
+
+// Map is a last-writer-wins map, a state based CRDT whose replicas converge
+// by merging each other's full state in any order, any number of times.
+//
+// Deletes leave tombstones so they win over older writes; a replica that
+// was partitioned for longer than the tombstone ttl can resurrect keys.
+//
+// Calling methods on `Map` is safe to do concurrently.
+type Map struct {
+	opts  Options
+	now   func() time.Time
+	clock *Clock
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// MergeStats are the result of merging remote state.
+type MergeStats struct {
+	// Updated is the number of entries the remote state replaced or added.
+	Updated int
+	// Rejected is the number of entries with timestamps too far ahead.
+	Rejected int
+}
+
+// New returns an empty map.
+func New(opts Options) *Map {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &Map{
+		opts:    opts,
+		now:     now,
+		clock:   NewClock(opts.Node, now),
+		entries: make(map[string]Entry),
+	}
+}
+
+// Get returns the value of a key.
+func (m *Map) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// Set sets the value of a key.
+func (m *Map) Set(key string, value []byte) (Entry, error) {
+	if err := validate(key, value); err != nil {
+		return Entry{}, err
+	}
+	return m.write(key, Entry{Value: append([]byte(nil), value...)}), nil
+}
+
+// Delete deletes a key, leaving a tombstone.
+func (m *Map) Delete(key string) Entry {
+	return m.write(key, Entry{Deleted: true})
+}
+
+func (m *Map) write(key string, e Entry) Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.Timestamp = m.clock.Now()
+	m.entries[key] = e
+	return e
+}
+
+// Entries returns the live entries with keys that start with a given prefix.
+func (m *Map) Entries(prefix string) map[string]Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output := make(map[string]Entry)
+	for key, e := range m.entries {
+		if !e.Deleted && strings.HasPrefix(key, prefix) {
+			output[key] = e
+		}
+	}
+	return output
+}
+
+// Len returns the number of live entries and tombstones.
+func (m *Map) Len() (live, tombstones int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.Deleted {
+			tombstones++
+		} else {
+			live++
+		}
+	}
+	return
+}
+
+// State returns the encoded full state of the map, including tombstones.
+func (m *Map) State() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.entries)
+}
+
+// Merge merges encoded remote state, keeping the entry with the later
+// timestamp for every key.
+func (m *Map) Merge(data []byte) (stats MergeStats, err error) {
+	var remote map[string]Entry
+	if err := json.Unmarshal(data, &remote); err != nil {
+		return stats, fmt.Errorf("gossipkv: %w", err)
+	}
+	keys := make([]string, 0, len(remote))
+	for key := range remote {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	horizon := m.now().Add(m.opts.MaxDriftOrDefault()).UnixNano()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		e := remote[key]
+		if e.Timestamp.Wall > horizon || validate(key, e.Value) != nil {
+			stats.Rejected++
+			continue
+		}
+		m.clock.Witness(e.Timestamp)
+		if local, ok := m.entries[key]; ok && !local.Timestamp.Less(e.Timestamp) {
+			continue
+		}
+		m.entries[key] = e
+		stats.Updated++
+	}
+	return stats, nil
+}
+
+// GC removes tombstones older than the tombstone ttl, returning how many were removed.
+func (m *Map) GC() (removed int) {
+	cutoff := m.now().Add(-m.opts.TombstoneTTLOrDefault()).UnixNano()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, e := range m.entries {
+		if e.Deleted && e.Timestamp.Wall < cutoff {
+			delete(m.entries, key)
+			removed++
+		}
+	}
+	return
+}
+
+func validate(key string, value []byte) error {
+	if key == "" || len(key) > MaxKeyLength {
+		return fmt.Errorf("gossipkv: key must be 1 to %d bytes", MaxKeyLength)
+	}
+	if len(value) > MaxValueSize {
+		return fmt.Errorf("gossipkv: value too large; %d > %d", len(value), MaxValueSize)
+	}
+	return nil
+}
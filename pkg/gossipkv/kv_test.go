@@ -0,0 +1,114 @@
+package gossipkv
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Clock(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewClock("a", func() time.Time { return now })
+	first := c.Now()
+	second := c.Now()
+	if !first.Less(second) || second.Logical != 1 {
+		t.Fatalf("expected the logical counter to order timestamps at the same wall time, got: %+v, %+v", first, second)
+	}
+
+	c.Witness(Timestamp{Wall: now.Add(time.Second).UnixNano(), Logical: 5, Node: "b"})
+	third := c.Now()
+	if third.Wall != now.Add(time.Second).UnixNano() || third.Logical != 6 || third.Node != "a" {
+		t.Fatalf("expected the clock to follow a witnessed timestamp, was: %+v", third)
+	}
+
+	now = now.Add(time.Hour)
+	if fourth := c.Now(); fourth.Wall != now.UnixNano() || fourth.Logical != 0 {
+		t.Fatalf("expected the clock to follow physical time, was: %+v", fourth)
+	}
+}
+
+func Test_Map_Merge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	a := New(Options{Node: "a", Now: clock})
+	b := New(Options{Node: "b", Now: clock})
+
+	if _, err := a.Set("config/interval", []byte("5s")); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if _, err := b.Set("config/interval", []byte("10s")); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if _, err := b.Set("checkpoint/AAPL", []byte("42")); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	b.Delete("checkpoint/AAPL")
+
+	exchange := func(from, to *Map) MergeStats {
+		data, err := from.State()
+		if err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+		stats, err := to.Merge(data)
+		if err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+		return stats
+	}
+	exchange(a, b)
+	exchange(b, a)
+	if stats := exchange(b, a); stats.Updated != 0 {
+		t.Fatalf("expected merging the same state twice to be a no-op, was: %+v", stats)
+	}
+
+	for _, m := range []*Map{a, b} {
+		// both writes have the same wall time and counter, so the tie is broken by node name.
+		if value, ok := m.Get("config/interval"); !ok || string(value) != "10s" {
+			t.Fatalf("expected the later write to win, was: %q", value)
+		}
+		if _, ok := m.Get("checkpoint/AAPL"); ok {
+			t.Fatalf("expected the delete to win")
+		}
+		if live, tombstones := m.Len(); live != 1 || tombstones != 1 {
+			t.Fatalf("expected 1 live entry and 1 tombstone, was: %d, %d", live, tombstones)
+		}
+	}
+
+	now = now.Add(2 * DefaultTombstoneTTL)
+	if removed := a.GC(); removed != 1 {
+		t.Fatalf("expected 1 tombstone to be collected, was: %d", removed)
+	}
+	if entries := a.Entries("config/"); len(entries) != 1 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func Test_Map_Merge_rejected(t *testing.T) {
+	testCases := []struct {
+		name  string
+		state string
+	}{
+		{
+			name:  "too far ahead",
+			state: `{"k":{"value":"dg==","timestamp":{"wall":9000000000000000000,"node":"b"}}}`,
+		},
+		{
+			name:  "empty key",
+			state: `{"":{"value":"dg==","timestamp":{"wall":1,"node":"b"}}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := New(Options{Node: "a"})
+			stats, err := m.Merge([]byte(tc.state))
+			if err != nil {
+				t.Fatalf("expected err to be unset, was: %v", err)
+			}
+			if stats.Rejected != 1 || stats.Updated != 0 {
+				t.Fatalf("expected the entry to be rejected, was: %+v", stats)
+			}
+		})
+	}
+	if _, err := New(Options{}).Merge([]byte("nope")); err == nil {
+		t.Fatalf("expected err to be set for invalid state")
+	}
+}
@@ -0,0 +1,221 @@
+package heartbeat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// DefaultRetransmitMult is the default multiplier of the number of times a
+// heartbeat is retransmitted, scaled by the log of the cluster size.
+const DefaultRetransmitMult = 3
+
+// ErrStale is returned by `Receive` for heartbeats that aren't newer than
+// the last heartbeat seen from their node.
+var ErrStale = errors.New("heartbeat: stale heartbeat")
+
+// Heartbeat is broadcast by a node every time it completes a tick.
+type Heartbeat struct {
+	Node string `json:"node"`
+	// Started is when the node's process started, in unix milliseconds,
+	// since the sequence starts over when the process restarts.
+	Started int64  `json:"started"`
+	Seq     uint64 `json:"seq"`
+	// TakenOver are the nodes whose entities the node pushes as their standby.
+	TakenOver []string `json:"takenOver,omitempty"`
+	// Epoch is the newest ownership epoch view the node created or observed.
+	Epoch int64 `json:"epoch,omitempty"`
+}
+
+// newer returns if the heartbeat was sent after another heartbeat of the same node.
+func (h Heartbeat) newer(than Heartbeat) bool {
+	if h.Started != than.Started {
+		return h.Started > than.Started
+	}
+	return h.Seq > than.Seq
+}
+
+// Seen is the last heartbeat seen from a node.
+type Seen struct {
+	Heartbeat Heartbeat `json:"heartbeat"`
+	// At is when the heartbeat was received, or when the node was first
+	// asked about if no heartbeat was received from it yet.
+	At time.Time `json:"at"`
+}
+
+// Options configure a `Tracker`.
+type Options struct {
+	// NumNodes returns the number of nodes in the cluster.
+	NumNodes       func() int
+	RetransmitMult int
+	// Prefix is prepended to every broadcast, e.g. a message type byte.
+	Prefix []byte
+	// Now returns the current time, defaulting to `time.Now`.
+	Now func() time.Time
+}
+
+// RetransmitMultOrDefault returns the retransmit multiplier or a default.
+func (o Options) RetransmitMultOrDefault() int {
+	if o.RetransmitMult > 0 {
+		return o.RetransmitMult
+	}
+	return DefaultRetransmitMult
+}
+
+// NowOrDefault returns the current time.
+func (o Options) NowOrDefault() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// Tracker broadcasts this node's heartbeats, and receives, rebroadcasts
+// and tracks the heartbeats of other nodes.
+//
+// Calling methods on `Tracker` is safe to do concurrently.
+type Tracker struct {
+	node    string
+	started int64
+	opts    Options
+	queue   *memberlist.TransmitLimitedQueue
+
+	mu   sync.Mutex
+	seq  uint64
+	seen map[string]Seen
+}
+
+// New returns a new tracker for the heartbeats of a given node.
+func New(node string, opts Options) *Tracker {
+	numNodes := opts.NumNodes
+	if numNodes == nil {
+		numNodes = func() int { return 1 }
+	}
+	return &Tracker{
+		node:    node,
+		started: opts.NowOrDefault().UnixMilli(),
+		opts:    opts,
+		queue: &memberlist.TransmitLimitedQueue{
+			NumNodes:       numNodes,
+			RetransmitMult: opts.RetransmitMultOrDefault(),
+		},
+		seen: make(map[string]Seen),
+	}
+}
+
+// Beat broadcasts a heartbeat of this node, along with the nodes it has
+// taken over and its epoch view.
+func (t *Tracker) Beat(takenOver []string, epoch int64) (Heartbeat, error) {
+	t.mu.Lock()
+	t.seq++
+	h := Heartbeat{Node: t.node, Started: t.started, Seq: t.seq, TakenOver: takenOver, Epoch: epoch}
+	t.seen[t.node] = Seen{Heartbeat: h, At: t.opts.NowOrDefault()}
+	t.mu.Unlock()
+	return h, t.broadcast(h)
+}
+
+// Receive decodes a heartbeat broadcast by another node, without the prefix,
+// and records and rebroadcasts it if it is newer than the last one seen.
+func (t *Tracker) Receive(msg []byte) (Heartbeat, error) {
+	var h Heartbeat
+	if err := json.Unmarshal(msg, &h); err != nil {
+		return Heartbeat{}, fmt.Errorf("heartbeat: %w", err)
+	}
+	t.mu.Lock()
+	if last, ok := t.seen[h.Node]; ok && !h.newer(last.Heartbeat) {
+		t.mu.Unlock()
+		return h, ErrStale
+	}
+	t.seen[h.Node] = Seen{Heartbeat: h, At: t.opts.NowOrDefault()}
+	t.mu.Unlock()
+	return h, t.broadcast(h)
+}
+
+// Missed returns the number of heartbeats a node has missed, sending one
+// per interval. A node no heartbeat was received from yet is given a
+// grace period as if it sent one when it was first asked about.
+func (t *Tracker) Missed(node string, interval time.Duration) int {
+	now := t.opts.NowOrDefault()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.seen[node]
+	if !ok {
+		t.seen[node] = Seen{Heartbeat: Heartbeat{Node: node}, At: now}
+		return 0
+	}
+	if interval <= 0 {
+		return 0
+	}
+	return int(now.Sub(last.At) / interval)
+}
+
+// TakenOverBy returns the nodes whose last heartbeat says they
+// have taken over a given node, sorted.
+func (t *Tracker) TakenOverBy(node string) (output []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.seen {
+		if slices.Contains(s.Heartbeat.TakenOver, node) {
+			output = append(output, s.Heartbeat.Node)
+		}
+	}
+	slices.Sort(output)
+	return
+}
+
+// Retain forgets the nodes that aren't in a given set, such that
+// a node that rejoins is given a grace period again.
+func (t *Tracker) Retain(nodes []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for node := range t.seen {
+		if node != t.node && !slices.Contains(nodes, node) {
+			delete(t.seen, node)
+		}
+	}
+}
+
+// Seen returns the last heartbeat seen from every node, including this node.
+func (t *Tracker) Seen() map[string]Seen {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	output := make(map[string]Seen, len(t.seen))
+	for node, s := range t.seen {
+		output[node] = s
+	}
+	return output
+}
+
+// GetBroadcasts returns queued broadcasts up to a byte limit, as
+// `memberlist.Delegate.GetBroadcasts` does.
+func (t *Tracker) GetBroadcasts(overhead, limit int) [][]byte {
+	return t.queue.GetBroadcasts(overhead, limit)
+}
+
+func (t *Tracker) broadcast(h Heartbeat) error {
+	msg, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	t.queue.QueueBroadcast(&broadcast{node: h.Node, msg: append(append([]byte(nil), t.opts.Prefix...), msg...)})
+	return nil
+}
+
+// broadcast is a queued heartbeat broadcast, which replaces
+// the queued broadcast of an older heartbeat of its node.
+type broadcast struct {
+	node string
+	msg  []byte
+}
+
+func (bc *broadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*broadcast)
+	return ok && o.node == bc.node
+}
+func (bc *broadcast) Message() []byte { return bc.msg }
+func (bc *broadcast) Finished()       {}
@@ -0,0 +1,71 @@
+package heartbeat
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_Tracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	prefix := []byte{9}
+	a := New("a", Options{Prefix: prefix, Now: clock})
+	b := New("b", Options{Prefix: prefix, Now: clock})
+
+	if missed := b.Missed("a", time.Second); missed != 0 {
+		t.Fatalf("expected a node never seen to be given a grace period, was: %d missed", missed)
+	}
+	now = now.Add(3 * time.Second)
+	if missed := b.Missed("a", time.Second); missed != 3 {
+		t.Fatalf("expected 3 missed heartbeats, was: %d", missed)
+	}
+
+	if _, err := a.Beat([]string{"c"}, 7); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	msgs := a.GetBroadcasts(0, 1400)
+	if len(msgs) != 1 || !bytes.HasPrefix(msgs[0], prefix) {
+		t.Fatalf("expected one prefixed broadcast, got: %q", msgs)
+	}
+	h, err := b.Receive(msgs[0][len(prefix):])
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if h.Node != "a" || h.Seq != 1 || h.Epoch != 7 {
+		t.Fatalf("unexpected heartbeat: %+v", h)
+	}
+	if missed := b.Missed("a", time.Second); missed != 0 {
+		t.Fatalf("expected no missed heartbeats, was: %d", missed)
+	}
+	if by := b.TakenOverBy("c"); !slices.Equal(by, []string{"a"}) {
+		t.Fatalf("expected c to be taken over by a, was: %v", by)
+	}
+	if rebroadcast := b.GetBroadcasts(0, 1400); len(rebroadcast) != 1 {
+		t.Fatalf("expected the heartbeat to be rebroadcast, got: %q", rebroadcast)
+	}
+	if _, err := b.Receive(msgs[0][len(prefix):]); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected a repeated heartbeat to be stale, was: %v", err)
+	}
+
+	// a restarted node's sequence starts over.
+	now = now.Add(time.Second)
+	restarted := New("a", Options{Prefix: prefix, Now: clock})
+	if _, err := restarted.Beat(nil, 0); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	msgs = restarted.GetBroadcasts(0, 1400)
+	if _, err := b.Receive(msgs[0][len(prefix):]); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if by := b.TakenOverBy("c"); len(by) != 0 {
+		t.Fatalf("expected c to no longer be taken over, was: %v", by)
+	}
+
+	b.Retain([]string{"c"})
+	if _, ok := b.Seen()["a"]; ok {
+		t.Fatalf("expected a to be forgotten")
+	}
+}
@@ -62,15 +62,47 @@ func OptDeploymentReplicas(replicas int32) DeploymentOption {
 	}
 }
 
+// OptDeploymentPort adds a named container port; it can be given multiple times.
 func OptDeploymentPort(name string, port int32, protocol corev1.Protocol) DeploymentOption {
 	return func(d *apiv1.Deployment) {
-		d.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{
-			{
-				Name:          name,
-				ContainerPort: port,
-				Protocol:      protocol,
+		d.Spec.Template.Spec.Containers[0].Ports = append(d.Spec.Template.Spec.Containers[0].Ports, corev1.ContainerPort{
+			Name:          name,
+			ContainerPort: port,
+			Protocol:      protocol,
+		})
+	}
+}
+
+// OptDeploymentHTTPProbes sets http liveness and readiness probes against a named container port.
+func OptDeploymentHTTPProbes(portName, livenessPath, readinessPath string) DeploymentOption {
+	return func(d *apiv1.Deployment) {
+		d.Spec.Template.Spec.Containers[0].LivenessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: livenessPath, Port: intstr.FromString(portName)},
 			},
+			PeriodSeconds:    10,
+			FailureThreshold: 3,
 		}
+		d.Spec.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: readinessPath, Port: intstr.FromString(portName)},
+			},
+			PeriodSeconds: 5,
+		}
+	}
+}
+
+// OptDeploymentArgs appends arguments to the container command; it can be given multiple times.
+func OptDeploymentArgs(args ...string) DeploymentOption {
+	return func(d *apiv1.Deployment) {
+		d.Spec.Template.Spec.Containers[0].Args = append(d.Spec.Template.Spec.Containers[0].Args, args...)
+	}
+}
+
+// OptDeploymentTerminationGracePeriod sets how long pods are given to stop after SIGTERM.
+func OptDeploymentTerminationGracePeriod(seconds int64) DeploymentOption {
+	return func(d *apiv1.Deployment) {
+		d.Spec.Template.Spec.TerminationGracePeriodSeconds = Ref(seconds)
 	}
 }
 
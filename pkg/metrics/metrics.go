@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds,
+// suitable for request and processing latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Registry holds metric families and writes them in
+// the prometheus text exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry returns a new registry.
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+// Counter registers and returns a new counter.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labelNames, nil)}
+}
+
+// Gauge registers and returns a new gauge.
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labelNames, nil)}
+}
+
+// GaugeFunc registers a gauge whose value is computed when metrics are written.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, "gauge", nil, nil)
+	f.fn = fn
+}
+
+// CounterFunc registers a counter whose value is computed when metrics are written.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, "counter", nil, nil)
+	f.fn = fn
+}
+
+// Histogram registers and returns a new histogram with the given upper bounds.
+//
+// If buckets is empty, `DefaultBuckets` are used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, "histogram", labelNames, buckets)}
+}
+
+// WriteTo writes all metrics in the prometheus text exposition format.
+func (r *Registry) WriteTo(wr io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(wr)
+	cw := &countingWriter{w: bw}
+	for _, f := range families {
+		f.writeTo(cw)
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// Handler returns an http handler serving the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = r.WriteTo(rw)
+	})
+}
+
+func (r *Registry) register(name, help, kind string, labelNames []string, buckets []float64) *family {
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	return f
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	f *family
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by a non-negative delta.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.f.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	f *family
+}
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Add adds a delta to the gauge for the given label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	f *family
+}
+
+// Observe records an observation for the given label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.update(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		for index, upperBound := range h.f.buckets {
+			if value <= upperBound {
+				s.counts[index]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+// family is a named metric with a set of labeled series.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+	fn         func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single labeled value; for histograms `value` holds
+// the sum and `counts` holds the cumulative bucket counts.
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+func (f *family) update(labelValues []string, fn func(*series)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+func (f *family) writeTo(cw *countingWriter) {
+	cw.printf("# HELP %s %s\n", f.name, escapeHelp(f.help))
+	cw.printf("# TYPE %s %s\n", f.name, f.kind)
+	if f.fn != nil {
+		cw.printf("%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bucketLabelNames := append(append([]string(nil), f.labelNames...), "le")
+	for _, key := range keys {
+		s := f.series[key]
+		labels := formatLabels(f.labelNames, s.labelValues)
+		if f.kind != "histogram" {
+			cw.printf("%s%s %s\n", f.name, labels, formatFloat(s.value))
+			continue
+		}
+		bucketLabelValues := append(append([]string(nil), s.labelValues...), "")
+		for index, upperBound := range f.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[index]
+			}
+			bucketLabelValues[len(s.labelValues)] = formatFloat(upperBound)
+			cw.printf("%s_bucket%s %d\n", f.name, formatLabels(bucketLabelNames, bucketLabelValues), count)
+		}
+		bucketLabelValues[len(s.labelValues)] = "+Inf"
+		cw.printf("%s_bucket%s %d\n", f.name, formatLabels(bucketLabelNames, bucketLabelValues), s.count)
+		cw.printf("%s_sum%s %s\n", f.name, labels, formatFloat(s.value))
+		cw.printf("%s_count%s %d\n", f.name, labels, s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for index, name := range names {
+		if index > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(values[index]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// countingWriter tracks bytes written and the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...any) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_Registry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Total requests.", "upstream", "result")
+	c.Inc("data-plane", "success")
+	c.Add(2, "data-plane", "failure")
+	g := r.Gauge("entities_owned", "Entities owned.")
+	g.Set(42)
+	r.GaugeFunc("members", "Cluster members.", func() float64 { return 3 })
+	h := r.Histogram("tick_duration_seconds", "Tick duration.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+
+	output := new(bytes.Buffer)
+	if _, err := r.WriteTo(output); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	expected := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{upstream="data-plane",result="failure"} 2
+requests_total{upstream="data-plane",result="success"} 1
+# HELP entities_owned Entities owned.
+# TYPE entities_owned gauge
+entities_owned 42
+# HELP members Cluster members.
+# TYPE members gauge
+members 3
+# HELP tick_duration_seconds Tick duration.
+# TYPE tick_duration_seconds histogram
+tick_duration_seconds_bucket{le="0.1"} 1
+tick_duration_seconds_bucket{le="1"} 2
+tick_duration_seconds_bucket{le="+Inf"} 2
+tick_duration_seconds_sum 0.55
+tick_duration_seconds_count 2
+`
+	if output.String() != expected {
+		t.Fatalf("actual metrics didn't match expected:\n%s", output.String())
+	}
+}
+
+func Test_escapeLabelValue(t *testing.T) {
+	if actual := escapeLabelValue("a\"b\\c\nd"); actual != `a\"b\\c\nd` {
+		t.Fatalf("unexpected escaped value: %s", actual)
+	}
+}
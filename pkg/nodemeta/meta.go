@@ -0,0 +1,194 @@
+package nodemeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Version is the current metadata encoding version.
+	//
+	// Version 2 added the cluster id; version 1 metadata is still
+	// decoded but will have an empty cluster id.
+	Version = 2
+
+	// DefaultCapacity is the nominal capacity weight of a node.
+	//
+	// Capacity is relative, that is a node with capacity 200 should
+	// be assigned roughly twice the entities of a node with capacity 100.
+	DefaultCapacity = 100
+
+	// maxStringLength is the maximum length of any string field, as
+	// string fields are length prefixed with a single byte.
+	maxStringLength = 255
+)
+
+var (
+	// ErrTruncated is returned by `Decode` if the metadata is too short.
+	ErrTruncated = errors.New("nodemeta: metadata truncated")
+)
+
+// State is the lifecycle state a node advertises.
+type State uint8
+
+// State values.
+const (
+	StateJoining State = iota
+	StateActive
+	StateDraining
+)
+
+// String returns a string form of the state.
+func (s State) String() string {
+	switch s {
+	case StateJoining:
+		return "joining"
+	case StateActive:
+		return "active"
+	case StateDraining:
+		return "draining"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Meta is the metadata a node advertises to the cluster.
+type Meta struct {
+	ClusterID    string `json:"clusterID"`
+	Zone         string `json:"zone"`
+	Capacity     uint16 `json:"capacity"`
+	BuildVersion string `json:"buildVersion"`
+	State        State  `json:"state"`
+}
+
+// CapacityOrDefault returns the capacity or a default.
+func (m Meta) CapacityOrDefault() uint16 {
+	if m.Capacity > 0 {
+		return m.Capacity
+	}
+	return DefaultCapacity
+}
+
+// Encode returns the compact binary form of the metadata.
+//
+// The layout is:
+//
+//	[version:1][state:1][capacity:2][len(zone):1][zone][len(buildVersion):1][buildVersion][len(clusterID):1][clusterID]
+//
+// with capacity in big endian order.
+func (m Meta) Encode() ([]byte, error) {
+	if len(m.ClusterID) > maxStringLength {
+		return nil, fmt.Errorf("nodemeta: cluster id too long; %d > %d", len(m.ClusterID), maxStringLength)
+	}
+	if len(m.Zone) > maxStringLength {
+		return nil, fmt.Errorf("nodemeta: zone too long; %d > %d", len(m.Zone), maxStringLength)
+	}
+	if len(m.BuildVersion) > maxStringLength {
+		return nil, fmt.Errorf("nodemeta: build version too long; %d > %d", len(m.BuildVersion), maxStringLength)
+	}
+	output := make([]byte, 0, 7+len(m.Zone)+len(m.BuildVersion)+len(m.ClusterID))
+	output = append(output, Version, byte(m.State))
+	output = binary.BigEndian.AppendUint16(output, m.Capacity)
+	output = append(output, byte(len(m.Zone)))
+	output = append(output, m.Zone...)
+	output = append(output, byte(len(m.BuildVersion)))
+	output = append(output, m.BuildVersion...)
+	output = append(output, byte(len(m.ClusterID)))
+	output = append(output, m.ClusterID...)
+	return output, nil
+}
+
+// Decode parses metadata from its compact binary form.
+func Decode(data []byte) (m Meta, err error) {
+	if len(data) < 1 {
+		err = ErrTruncated
+		return
+	}
+	version := data[0]
+	if version < 1 || version > Version {
+		err = fmt.Errorf("nodemeta: unsupported version %d", version)
+		return
+	}
+	r := reader{data: data[1:]}
+	m.State = State(r.byte())
+	m.Capacity = r.uint16()
+	m.Zone = r.string()
+	m.BuildVersion = r.string()
+	if version >= 2 {
+		m.ClusterID = r.string()
+	}
+	err = r.err
+	return
+}
+
+// CompatibleBuildVersions returns if two build versions can participate
+// in the same cluster, that is if they share a major version.
+//
+// Development builds (an empty or "dev" version) are compatible with any version.
+func CompatibleBuildVersions(a, b string) bool {
+	if isDevBuild(a) || isDevBuild(b) {
+		return true
+	}
+	return majorVersion(a) == majorVersion(b)
+}
+
+func isDevBuild(version string) bool {
+	return version == "" || version == "dev"
+}
+
+// majorVersion returns the major component of a version
+// like `v1.2.3`, i.e. `1`.
+func majorVersion(version string) string {
+	version = strings.TrimPrefix(version, "v")
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
+
+// reader reads fields from encoded metadata, recording
+// the first error it encounters.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = ErrTruncated
+		return nil
+	}
+	output := r.data[:n]
+	r.data = r.data[n:]
+	return output
+}
+
+func (r *reader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	length := int(r.byte())
+	if b := r.take(length); b != nil {
+		return string(b)
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package nodemeta
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_Meta_EncodeDecode(t *testing.T) {
+	m := Meta{
+		ClusterID:    "production",
+		Zone:         "us-west-2a",
+		Capacity:     250,
+		BuildVersion: "v1.2.3",
+		State:        StateDraining,
+	}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if decoded != m {
+		t.Fatalf("expected decoded metadata to match, was: %#v", decoded)
+	}
+}
+
+func Test_Decode_truncated(t *testing.T) {
+	data, _ := Meta{ClusterID: "production", Zone: "us-west-2a", BuildVersion: "v1.2.3"}.Encode()
+	for x := 0; x < len(data); x++ {
+		if _, err := Decode(data[:x]); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("expected truncated error for length %d, was: %v", x, err)
+		}
+	}
+}
+
+func Test_CompatibleBuildVersions(t *testing.T) {
+	testCases := []struct {
+		A, B     string
+		Expected bool
+	}{
+		{"v1.2.3", "v1.4.0", true},
+		{"v1.2.3", "v2.0.0", false},
+		{"dev", "v2.0.0", true},
+		{"", "v1.0.0", true},
+	}
+	for _, tc := range testCases {
+		if actual := CompatibleBuildVersions(tc.A, tc.B); actual != tc.Expected {
+			t.Fatalf("expected %q and %q compatible to be %v, was %v", tc.A, tc.B, tc.Expected, actual)
+		}
+	}
+}
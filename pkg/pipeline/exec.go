@@ -0,0 +1,343 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gossip/pkg/types"
+	"gossip/pkg/upstream"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultExecTimeout is the default time a process may take to handle a batch.
+	DefaultExecTimeout = 5 * time.Second
+	// DefaultExecBackoff is the default delay before restarting a failed process.
+	DefaultExecBackoff = time.Second
+	// DefaultExecMaxBackoff is the default maximum delay before restarting a failed process.
+	DefaultExecMaxBackoff = time.Minute
+)
+
+// What exec stages do with values if the process fails.
+const (
+	ExecOnErrorPass = "pass"
+	ExecOnErrorDrop = "drop"
+)
+
+// Exec hands values to a long running external process, which reads
+// newline delimited json records on stdin and writes exactly one record
+// per input record to stdout, in the same order and with the same id.
+//
+// The process is started with the first batch, and restarted with
+// exponential backoff if it exits, writes something unexpected, or
+// takes longer than the timeout to handle a batch.
+type Exec struct {
+	// Command is the executable and its arguments.
+	Command []string `yaml:"command"`
+	// Timeout bounds the time the process may take to handle a batch.
+	Timeout time.Duration `yaml:"timeout"`
+	// OnError is either `pass` to keep values unchanged if the process
+	// fails (the default), or `drop` to drop them.
+	OnError string `yaml:"onError"`
+	// Backoff and MaxBackoff bound the jittered delay before restarting
+	// a failed process, which doubles with every consecutive failure.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// TimeoutOrDefault returns the timeout or a default.
+func (e Exec) TimeoutOrDefault() time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return DefaultExecTimeout
+}
+
+// BackoffOrDefault returns the backoff or a default.
+func (e Exec) BackoffOrDefault() time.Duration {
+	if e.Backoff > 0 {
+		return e.Backoff
+	}
+	return DefaultExecBackoff
+}
+
+// MaxBackoffOrDefault returns the maximum backoff or a default.
+func (e Exec) MaxBackoffOrDefault() time.Duration {
+	if e.MaxBackoff > 0 {
+		return e.MaxBackoff
+	}
+	return DefaultExecMaxBackoff
+}
+
+func (e Exec) compile() (stage, error) {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return nil, errors.New("exec: command must be set")
+	}
+	switch e.OnError {
+	case "", ExecOnErrorPass, ExecOnErrorDrop:
+	default:
+		return nil, fmt.Errorf("exec: unknown onError %q", e.OnError)
+	}
+	return &execStage{
+		command: e.Command,
+		timeout: e.TimeoutOrDefault(),
+		drop:    e.OnError == ExecOnErrorDrop,
+		retry: upstream.RetryPolicy{
+			BaseDelay: e.BackoffOrDefault(),
+			MaxDelay:  e.MaxBackoffOrDefault(),
+		},
+	}, nil
+}
+
+// errRestarting is returned while waiting to restart a failed process.
+var errRestarting = errors.New("restarting")
+
+// ExecRecord is a value as exchanged with the process of an exec stage.
+type ExecRecord struct {
+	// ID is the id the entity was fetched as, which the process must echo.
+	ID string `json:"id"`
+	// Entity is the id the value is pushed as, which the process must
+	// set unless it drops the value; a record without one is an error,
+	// handled like a failure of the process for that value.
+	Entity string            `json:"entity"`
+	Value  float64           `json:"value"`
+	At     time.Time         `json:"at"`
+	Info   *types.EntityInfo `json:"info,omitempty"`
+	// Drop is set by the process to drop the value.
+	Drop bool `json:"drop,omitempty"`
+}
+
+type execStage struct {
+	command []string
+	timeout time.Duration
+	drop    bool
+	retry   upstream.RetryPolicy
+	// p is the pipeline the stage is part of, for its error callback.
+	p *Pipeline
+
+	proc     *execProcess
+	failures int
+	retryAt  time.Time
+}
+
+func (es *execStage) name() string { return "exec" }
+
+func (es *execStage) apply(v *Value) bool {
+	return es.applyBatch([]*Value{v})[0]
+}
+
+func (es *execStage) applyBatch(values []*Value) (keep []bool) {
+	keep = make([]bool, len(values))
+	records, err := es.roundTrip(values)
+	if err != nil {
+		es.fail(err)
+		for index := range keep {
+			keep[index] = !es.drop
+		}
+		return
+	}
+	es.failures = 0
+	var invalid int
+	for index, r := range records {
+		if r.Drop {
+			continue
+		}
+		if r.Entity == "" {
+			invalid++
+			keep[index] = !es.drop
+			continue
+		}
+		values[index].Entity = r.Entity
+		values[index].Value = r.Value
+		keep[index] = true
+	}
+	if invalid > 0 && es.p != nil && es.p.OnExecError != nil {
+		es.p.OnExecError(strings.Join(es.command, " "), fmt.Errorf("%d records without an entity", invalid))
+	}
+	return
+}
+
+// roundTrip writes values to the process, starting it if needed,
+// and reads back one record per value.
+func (es *execStage) roundTrip(values []*Value) ([]ExecRecord, error) {
+	if es.proc != nil {
+		select {
+		case <-es.proc.exited:
+			return nil, fmt.Errorf("exited: %v", es.proc.err)
+		default:
+		}
+	}
+	if es.proc == nil {
+		if wait := time.Until(es.retryAt); wait > 0 {
+			return nil, fmt.Errorf("%w in %s", errRestarting, wait.Round(time.Millisecond))
+		}
+		proc, err := startExecProcess(es.command)
+		if err != nil {
+			return nil, err
+		}
+		es.proc = proc
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range values {
+		r := ExecRecord{ID: v.ID, Entity: v.Entity, Value: v.Value, At: v.At}
+		if v.Info != (types.EntityInfo{}) {
+			info := v.Info
+			r.Info = &info
+		}
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+
+	records := make([]ExecRecord, len(values))
+	written, read := make(chan error, 1), make(chan error, 1)
+	go func() {
+		_, err := es.proc.stdin.Write(buf.Bytes())
+		written <- err
+	}()
+	go func() {
+		read <- es.proc.read(values, records)
+	}()
+	timer := time.NewTimer(es.timeout)
+	defer timer.Stop()
+	timedOut := fmt.Errorf("timed out after %s", es.timeout)
+	select {
+	case err := <-read:
+		if err != nil {
+			// The reader's error says more than a broken pipe from the writer.
+			es.proc.kill()
+			<-written
+			return nil, err
+		}
+		select {
+		case err = <-written:
+			return records, err
+		case <-timer.C:
+			es.proc.kill()
+			<-written
+			return nil, timedOut
+		}
+	case <-timer.C:
+		es.proc.kill()
+		<-written
+		<-read
+		return nil, timedOut
+	}
+}
+
+// fail stops the process and schedules a restart.
+func (es *execStage) fail(err error) {
+	if es.proc != nil {
+		es.proc.kill()
+		es.proc = nil
+	}
+	if !errors.Is(err, errRestarting) {
+		es.failures++
+		es.retryAt = time.Now().Add(es.retry.Backoff(es.failures))
+	}
+	if es.p != nil && es.p.OnExecError != nil {
+		es.p.OnExecError(strings.Join(es.command, " "), err)
+	}
+}
+
+// close asks the process to exit by closing its stdin,
+// and kills it if it doesn't exit within the timeout.
+func (es *execStage) close() error {
+	if es.proc == nil {
+		return nil
+	}
+	proc := es.proc
+	es.proc = nil
+	_ = proc.stdin.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(es.timeout):
+	}
+	proc.kill()
+	return nil
+}
+
+// execProcess is a running exec stage process.
+//
+// The pipes are created explicitly rather than with `StdinPipe` and
+// `StdoutPipe`, as waiting for the process closes those, which could
+// race with reading the last records the process wrote.
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	reader *bufio.Reader
+	exited chan struct{}
+	// err is the result of waiting for the process, set once exited is closed.
+	err error
+}
+
+func startExecProcess(command []string) (*execProcess, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		_ = stdinR.Close()
+		_ = stdinW.Close()
+		return nil, err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	_ = stdinR.Close()
+	_ = stdoutW.Close()
+	if err != nil {
+		_ = stdinW.Close()
+		_ = stdoutR.Close()
+		return nil, err
+	}
+	proc := &execProcess{
+		cmd:    cmd,
+		stdin:  stdinW,
+		stdout: stdoutR,
+		reader: bufio.NewReader(stdoutR),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.exited)
+	}()
+	return proc, nil
+}
+
+// read reads one record per value, checking that ids are echoed.
+func (proc *execProcess) read(values []*Value, records []ExecRecord) error {
+	for index, v := range values {
+		line, err := proc.reader.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("reading record %d: %w", index, err)
+		}
+		if err := json.Unmarshal(line, &records[index]); err != nil {
+			return fmt.Errorf("decoding record %d: %w", index, err)
+		}
+		if records[index].ID != v.ID {
+			return fmt.Errorf("record %d: expected id %q, got %q", index, v.ID, records[index].ID)
+		}
+	}
+	return nil
+}
+
+// kill kills the process and closes its pipes, which also
+// unblocks any pending writes and reads, and waits for it to exit.
+func (proc *execProcess) kill() {
+	_ = proc.cmd.Process.Kill()
+	_ = proc.stdin.Close()
+	_ = proc.stdout.Close()
+	<-proc.exited
+}
@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Exec(t *testing.T) {
+	// Renames AAPL and drops MSFT, echoing everything else.
+	script := `while IFS= read -r line; do
+  case "$line" in
+    *'"id":"MSFT"'*) echo '{"id":"MSFT","drop":true}' ;;
+    *) printf '%s\n' "$line" | sed 's/"entity":"AAPL"/"entity":"apple"/' ;;
+  esac
+done`
+	p, err := New(Config{Stages: []StageConfig{
+		{Scale: &Scale{Factor: 2}},
+		{Exec: &Exec{Command: []string{"sh", "-c", script}}},
+	}})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	defer p.Close()
+	var execErr error
+	p.OnExecError = func(_ string, err error) { execErr = err }
+
+	for range 2 {
+		output, effects := p.Apply(time.Unix(1000, 0), map[string]int64{"AAPL": 1, "GOOG": 2, "MSFT": 3}, nil)
+		if execErr != nil {
+			t.Fatalf("expected exec err to be unset, was: %v", execErr)
+		}
+		if len(output) != 2 || output["apple"] != 2 || output["GOOG"] != 4 {
+			t.Fatalf("unexpected output: %v", output)
+		}
+		for _, e := range effects {
+			if e.ID == "MSFT" && e.DroppedBy != "1:exec" {
+				t.Fatalf("expected MSFT to be dropped by exec, was: %+v", e)
+			}
+		}
+	}
+}
+
+func Test_Exec_emptyEntity(t *testing.T) {
+	// Clears the entity of AAPL without dropping it, echoing everything else.
+	script := `while IFS= read -r line; do
+  printf '%s\n' "$line" | sed 's/"entity":"AAPL"/"entity":""/'
+done`
+	testCases := []struct {
+		name    string
+		onError string
+		output  map[string]int64
+	}{
+		{
+			name:   "pass",
+			output: map[string]int64{"AAPL": 1, "GOOG": 2},
+		},
+		{
+			name:    "drop",
+			onError: ExecOnErrorDrop,
+			output:  map[string]int64{"GOOG": 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(Config{Stages: []StageConfig{{Exec: &Exec{Command: []string{"sh", "-c", script}, OnError: tc.onError}}}})
+			if err != nil {
+				t.Fatalf("expected err to be unset, was: %v", err)
+			}
+			defer p.Close()
+			var execErr error
+			p.OnExecError = func(_ string, err error) { execErr = err }
+
+			output, _ := p.Apply(time.Unix(1000, 0), map[string]int64{"AAPL": 1, "GOOG": 2}, nil)
+			if !maps.Equal(output, tc.output) {
+				t.Fatalf("expected output %v, got: %v", tc.output, output)
+			}
+			if execErr == nil || !strings.Contains(execErr.Error(), "1 records without an entity") {
+				t.Fatalf("expected exec err to count the record without an entity, was: %v", execErr)
+			}
+		})
+	}
+}
+
+func Test_Exec_failure(t *testing.T) {
+	testCases := []struct {
+		name    string
+		exec    Exec
+		output  int
+		errText string
+	}{
+		{
+			name:    "exit",
+			exec:    Exec{Command: []string{"true"}},
+			output:  1,
+			errText: "reading record 0",
+		},
+		{
+			name:    "drop on error",
+			exec:    Exec{Command: []string{"true"}, OnError: ExecOnErrorDrop},
+			output:  0,
+			errText: "reading record 0",
+		},
+		{
+			name:    "wrong id",
+			exec:    Exec{Command: []string{"echo", `{"id":"other"}`}},
+			output:  1,
+			errText: `expected id "AAPL"`,
+		},
+		{
+			name:    "timeout",
+			exec:    Exec{Command: []string{"sleep", "10"}, Timeout: 50 * time.Millisecond},
+			output:  1,
+			errText: "timed out",
+		},
+		{
+			name:    "missing",
+			exec:    Exec{Command: []string{"/does/not/exist"}},
+			output:  1,
+			errText: "no such file",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.exec.Backoff = time.Hour
+			p, err := New(Config{Stages: []StageConfig{{Exec: &tc.exec}}})
+			if err != nil {
+				t.Fatalf("expected err to be unset, was: %v", err)
+			}
+			defer p.Close()
+			var execErr error
+			p.OnExecError = func(_ string, err error) { execErr = err }
+
+			output, _ := p.Apply(time.Unix(1000, 0), map[string]int64{"AAPL": 1}, nil)
+			if len(output) != tc.output {
+				t.Fatalf("expected %d values, got: %v", tc.output, output)
+			}
+			if execErr == nil || !strings.Contains(execErr.Error(), tc.errText) {
+				t.Fatalf("expected exec err to contain %q, was: %v", tc.errText, execErr)
+			}
+
+			p.Apply(time.Unix(1010, 0), map[string]int64{"AAPL": 1}, nil)
+			if !strings.Contains(execErr.Error(), "restarting in") {
+				t.Fatalf("expected the restart to back off, was: %v", execErr)
+			}
+		})
+	}
+}
+
+func Test_Exec_config(t *testing.T) {
+	if _, err := Parse([]byte("stages: [{exec: {}}]")); err == nil {
+		t.Fatalf("expected err to be set for a missing command")
+	}
+	if _, err := Parse([]byte("stages: [{exec: {command: [cat], onError: retry}}]")); err == nil {
+		t.Fatalf("expected err to be set for an unknown onError")
+	}
+	if _, err := Parse([]byte("stages: [{exec: {command: [cat], timeout: 1s, backoff: 2s}}]")); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+}
@@ -0,0 +1,520 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"gossip/pkg/polling"
+	"gossip/pkg/types"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	yaml "sigs.k8s.io/yaml/goyaml.v2"
+)
+
+// Config is a pipeline configuration, e.g.
+//
+//	stages:
+//	  - exclude:
+//	      testIssue: true
+//	  - include:
+//	      regex: '^[A-Z]+$'
+//	      etf: true
+//	  - scale:
+//	      factor: 0.000001
+//	  - rate:
+//	      per: 1s
+//	  - rename:
+//	      regex: '^(.*)$'
+//	      replacement: 'etf.$1'
+//	  - exec:
+//	      command: ['/usr/local/bin/enrich', '--region=eu']
+//	      timeout: 2s
+//
+// Stages are applied in order, and each stage entry sets exactly one stage.
+type Config struct {
+	Stages []StageConfig `yaml:"stages"`
+}
+
+// StageConfig configures a single stage; exactly one field must be set.
+type StageConfig struct {
+	// Include drops entities that don't match.
+	Include *Filter `yaml:"include"`
+	// Exclude drops entities that match.
+	Exclude *Filter `yaml:"exclude"`
+	// Scale multiplies values by a factor.
+	Scale *Scale `yaml:"scale"`
+	// Delta replaces values with the change since the previous value,
+	// dropping the first value seen for an entity.
+	Delta *Delta `yaml:"delta"`
+	// Rate replaces values with the rate of change since the previous
+	// value, dropping the first value seen for an entity.
+	Rate *Rate `yaml:"rate"`
+	// Rename renames entities.
+	Rename *Rename `yaml:"rename"`
+	// Exec hands values to an external process.
+	Exec *Exec `yaml:"exec"`
+}
+
+// Filter matches entities by a regular expression on the entity id,
+// and by source and data-plane metadata as polling groups do.
+type Filter struct {
+	Regex         string `yaml:"regex"`
+	polling.Match `yaml:",inline"`
+}
+
+// Scale multiplies values by a factor.
+type Scale struct {
+	Factor float64 `yaml:"factor"`
+}
+
+// Delta replaces values with the change since the previous value.
+type Delta struct{}
+
+// Rate replaces values with the rate of change per a given duration.
+type Rate struct {
+	Per time.Duration `yaml:"per"`
+}
+
+// Rename replaces matches of a regular expression in the entity id,
+// with `$1` style references to submatches in the replacement.
+type Rename struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+}
+
+// Load reads a pipeline configuration from a yaml file and compiles it.
+func Load(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse parses a yaml pipeline configuration and compiles it.
+func Parse(data []byte) (*Pipeline, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, err
+	}
+	return New(c)
+}
+
+// New compiles a pipeline configuration.
+func New(c Config) (*Pipeline, error) {
+	p := new(Pipeline)
+	for index, sc := range c.Stages {
+		s, err := sc.compile()
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", index, err)
+		}
+		if es, ok := s.(*execStage); ok {
+			es.p = p
+		}
+		p.stages = append(p.stages, s)
+	}
+	return p, nil
+}
+
+func (sc StageConfig) compile() (s stage, err error) {
+	var set int
+	if sc.Include != nil {
+		set++
+		s, err = sc.Include.compile("include", true)
+	}
+	if sc.Exclude != nil {
+		set++
+		s, err = sc.Exclude.compile("exclude", false)
+	}
+	if sc.Scale != nil {
+		set++
+		s = &scaleStage{factor: sc.Scale.Factor}
+	}
+	if sc.Delta != nil {
+		set++
+		s = &deltaStage{state: make(map[string]Observation)}
+	}
+	if sc.Rate != nil {
+		set++
+		if sc.Rate.Per <= 0 {
+			err = fmt.Errorf("rate: per must be positive")
+		}
+		s = &rateStage{per: sc.Rate.Per, deltaStage: deltaStage{state: make(map[string]Observation)}}
+	}
+	if sc.Rename != nil {
+		set++
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(sc.Rename.Regex); err == nil {
+			s = &renameStage{re: re, replacement: sc.Rename.Replacement}
+		}
+	}
+	if sc.Exec != nil {
+		set++
+		s, err = sc.Exec.compile()
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("expected exactly one stage, got %d", set)
+	}
+	return
+}
+
+func (f Filter) compile(name string, include bool) (stage, error) {
+	fs := &filterStage{kind: name, include: include, match: f.Match}
+	if f.Regex != "" {
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fs.re = re
+	}
+	return fs, nil
+}
+
+// Pipeline filters and transforms fetched values before they're pushed.
+//
+// Calling methods on `Pipeline` is safe to do concurrently.
+type Pipeline struct {
+	mu     sync.Mutex
+	stages []stage
+
+	// OnExecError, if set, is called with the pipeline lock held
+	// when an exec stage fails to handle a batch.
+	OnExecError func(command string, err error)
+}
+
+// Value is a value passing through the pipeline.
+type Value struct {
+	// ID is the id the entity was fetched as; stage state is kept by id.
+	ID string
+	// Entity is the id the value is pushed as, which renames change.
+	Entity string
+	Value  float64
+	At     time.Time
+	Info   types.EntityInfo
+}
+
+// Effect is the effect of the pipeline on a value.
+type Effect struct {
+	ID     string `json:"id"`
+	Input  int64  `json:"input"`
+	Entity string `json:"entity,omitempty"`
+	Output int64  `json:"output,omitempty"`
+	// DroppedBy is the stage that dropped the value, e.g. `1:exclude`,
+	// or `collision` if another value was output under the same entity.
+	DroppedBy string `json:"droppedBy,omitempty"`
+	// CollidesWith is the id of the value kept in a collision.
+	CollidesWith string `json:"collidesWith,omitempty"`
+}
+
+// DroppedByCollision is the `DroppedBy` of values dropped because a value
+// with a lower id was renamed to, or kept, the same entity.
+const DroppedByCollision = "collision"
+
+// Len returns the number of stages.
+func (p *Pipeline) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.stages)
+}
+
+// NeedsInfo returns if any stage filters on entity metadata.
+func (p *Pipeline) NeedsInfo() bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.stages {
+		if fs, ok := s.(*filterStage); ok && fs.match.NeedsInfo() {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply runs values fetched at a given time through the pipeline, returning
+// the values to push and the effect on each value.
+//
+// Each stage sees every value that passed the previous stages before the
+// next stage runs, so an exec stage handles all of them in one round trip.
+func (p *Pipeline) Apply(at time.Time, values map[string]int64, info func(id string) types.EntityInfo) (output map[string]int64, effects []Effect) {
+	ids := make([]string, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	vs := make([]*Value, len(ids))
+	effects = make([]Effect, len(ids))
+	for index, id := range ids {
+		vs[index] = &Value{ID: id, Entity: id, Value: float64(values[id]), At: at}
+		if info != nil {
+			vs[index].Info = info(id)
+		}
+		effects[index] = Effect{ID: id, Input: values[id]}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	live := make([]int, len(vs))
+	for index := range vs {
+		live[index] = index
+	}
+	for stageIndex, s := range p.stages {
+		if len(live) == 0 {
+			break
+		}
+		batch := make([]*Value, len(live))
+		for index, vi := range live {
+			batch[index] = vs[vi]
+		}
+		keep := applyAll(s, batch)
+		kept := live[:0]
+		for index, vi := range live {
+			if keep[index] {
+				kept = append(kept, vi)
+				continue
+			}
+			effects[vi].DroppedBy = fmt.Sprintf("%d:%s", stageIndex, s.name())
+		}
+		live = kept
+	}
+
+	output = make(map[string]int64, len(live))
+	claimed := make(map[string]string, len(live))
+	for _, vi := range live {
+		effects[vi].Entity = vs[vi].Entity
+		if id, ok := claimed[vs[vi].Entity]; ok {
+			effects[vi].DroppedBy = DroppedByCollision
+			effects[vi].CollidesWith = id
+			continue
+		}
+		claimed[vs[vi].Entity] = vs[vi].ID
+		effects[vi].Output = int64(math.Round(vs[vi].Value))
+		output[effects[vi].Entity] = effects[vi].Output
+	}
+	return
+}
+
+// Export returns the state stages keep for the given ids, by id and by
+// stage, keyed like `1:rate`, such that another node can continue from it.
+func (p *Pipeline) Export(ids []string) map[string]map[string]Observation {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	output := make(map[string]map[string]Observation)
+	for index, s := range p.stages {
+		ss, ok := s.(statefulStage)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", index, s.name())
+		for _, id := range ids {
+			o, ok := ss.export(id)
+			if !ok {
+				continue
+			}
+			if output[id] == nil {
+				output[id] = make(map[string]Observation)
+			}
+			output[id][key] = o
+		}
+	}
+	return output
+}
+
+// Import restores state exported by another node's pipeline, skipping
+// stages that don't match this pipeline's stages, and keeping state
+// held that is newer.
+func (p *Pipeline) Import(state map[string]map[string]Observation) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for index, s := range p.stages {
+		ss, ok := s.(statefulStage)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", index, s.name())
+		for id, observations := range state {
+			if o, ok := observations[key]; ok {
+				ss.restore(id, o)
+			}
+		}
+	}
+}
+
+// Close stops the processes of exec stages.
+func (p *Pipeline) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, s := range p.stages {
+		if es, ok := s.(*execStage); ok {
+			errs = append(errs, es.close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Retain forgets the state of entities that aren't in a given set of ids.
+func (p *Pipeline) Retain(ids []string) {
+	if p == nil {
+		return
+	}
+	keep := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		keep[id] = struct{}{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.stages {
+		if ss, ok := s.(statefulStage); ok {
+			ss.retain(keep)
+		}
+	}
+}
+
+// stage is a compiled pipeline stage.
+type stage interface {
+	name() string
+	// apply transforms a value in place, returning false to drop it.
+	apply(v *Value) bool
+}
+
+// batchStage is a stage that transforms all values at once.
+type batchStage interface {
+	// applyBatch transforms values in place, returning false for values to drop.
+	applyBatch(values []*Value) (keep []bool)
+}
+
+// applyAll applies a stage to a batch of values.
+func applyAll(s stage, values []*Value) []bool {
+	if bs, ok := s.(batchStage); ok {
+		return bs.applyBatch(values)
+	}
+	keep := make([]bool, len(values))
+	for index, v := range values {
+		keep[index] = s.apply(v)
+	}
+	return keep
+}
+
+// statefulStage is a stage that keeps state per entity.
+type statefulStage interface {
+	retain(keep map[string]struct{})
+	export(id string) (Observation, bool)
+	// restore sets the state of an id unless the state held is newer.
+	restore(id string, o Observation)
+}
+
+type filterStage struct {
+	kind    string
+	include bool
+	re      *regexp.Regexp
+	match   polling.Match
+}
+
+func (fs *filterStage) name() string { return fs.kind }
+
+func (fs *filterStage) apply(v *Value) bool {
+	matched := (fs.re == nil || fs.re.MatchString(v.ID)) && fs.match.Matches(v.ID, v.Info)
+	return matched == fs.include
+}
+
+type scaleStage struct {
+	factor float64
+}
+
+func (ss *scaleStage) name() string { return "scale" }
+
+func (ss *scaleStage) apply(v *Value) bool {
+	v.Value *= ss.factor
+	return true
+}
+
+// Observation is the previous value seen for an entity.
+type Observation struct {
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+type deltaStage struct {
+	state map[string]Observation
+}
+
+func (ds *deltaStage) name() string { return "delta" }
+
+func (ds *deltaStage) apply(v *Value) bool {
+	previous, ok := ds.state[v.ID]
+	ds.state[v.ID] = Observation{Value: v.Value, At: v.At}
+	if !ok {
+		return false
+	}
+	v.Value -= previous.Value
+	return true
+}
+
+func (ds *deltaStage) retain(keep map[string]struct{}) {
+	for id := range ds.state {
+		if _, ok := keep[id]; !ok {
+			delete(ds.state, id)
+		}
+	}
+}
+
+func (ds *deltaStage) export(id string) (Observation, bool) {
+	o, ok := ds.state[id]
+	return o, ok
+}
+
+func (ds *deltaStage) restore(id string, o Observation) {
+	if current, ok := ds.state[id]; !ok || o.At.After(current.At) {
+		ds.state[id] = o
+	}
+}
+
+type rateStage struct {
+	deltaStage
+	per time.Duration
+}
+
+func (rs *rateStage) name() string { return "rate" }
+
+func (rs *rateStage) apply(v *Value) bool {
+	previous, ok := rs.state[v.ID]
+	if !rs.deltaStage.apply(v) {
+		return false
+	}
+	elapsed := v.At.Sub(previous.At)
+	if !ok || elapsed <= 0 {
+		return false
+	}
+	v.Value = v.Value / elapsed.Seconds() * rs.per.Seconds()
+	return true
+}
+
+type renameStage struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func (rs *renameStage) name() string { return "rename" }
+
+func (rs *renameStage) apply(v *Value) bool {
+	v.Entity = rs.re.ReplaceAllString(v.Entity, rs.replacement)
+	return v.Entity != ""
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"gossip/pkg/types"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+stages:
+  - exclude:
+      testIssue: true
+  - include:
+      regex: '^A'
+  - scale:
+      factor: 0.5
+  - rename:
+      regex: '^(.*)$'
+      replacement: 'prices.$1'
+`
+
+func Test_Pipeline_Apply(t *testing.T) {
+	p, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if !p.NeedsInfo() {
+		t.Fatalf("expected pipeline to need entity info")
+	}
+	infos := map[string]types.EntityInfo{"ATEST": {TestIssue: true}}
+	output, effects := p.Apply(time.Unix(1000, 0), map[string]int64{
+		"AAPL":  10,
+		"ATEST": 10,
+		"MSFT":  10,
+	}, func(id string) types.EntityInfo { return infos[id] })
+	if len(output) != 1 || output["prices.AAPL"] != 5 {
+		t.Fatalf("unexpected output: %v", output)
+	}
+	dropped := make(map[string]string)
+	for _, e := range effects {
+		dropped[e.ID] = e.DroppedBy
+	}
+	if dropped["ATEST"] != "0:exclude" || dropped["MSFT"] != "1:include" || dropped["AAPL"] != "" {
+		t.Fatalf("unexpected effects: %+v", effects)
+	}
+}
+
+func Test_Pipeline_collision(t *testing.T) {
+	p, err := Parse([]byte("stages: [{rename: {regex: '^[a-z]+/', replacement: ''}}]"))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	output, effects := p.Apply(time.Unix(1000, 0), map[string]int64{
+		"a/AAPL": 1,
+		"b/AAPL": 2,
+		"b/MSFT": 3,
+	}, nil)
+	if len(output) != 2 || output["AAPL"] != 1 || output["MSFT"] != 3 {
+		t.Fatalf("unexpected output: %v", output)
+	}
+	if effects[1].ID != "b/AAPL" || effects[1].DroppedBy != DroppedByCollision || effects[1].CollidesWith != "a/AAPL" {
+		t.Fatalf("unexpected effect: %+v", effects[1])
+	}
+}
+
+func Test_Pipeline_rate(t *testing.T) {
+	p, err := Parse([]byte("stages: [{rate: {per: 1s}}]"))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if output, _ := p.Apply(time.Unix(1000, 0), map[string]int64{"AAPL": 100}, nil); len(output) != 0 {
+		t.Fatalf("expected the first value to be dropped, was: %v", output)
+	}
+	if output, _ := p.Apply(time.Unix(1010, 0), map[string]int64{"AAPL": 200}, nil); output["AAPL"] != 10 {
+		t.Fatalf("expected a rate of 10/s, was: %v", output)
+	}
+
+	p.Retain(nil)
+	if output, _ := p.Apply(time.Unix(1020, 0), map[string]int64{"AAPL": 300}, nil); len(output) != 0 {
+		t.Fatalf("expected the state to be forgotten, was: %v", output)
+	}
+}
+
+func Test_Pipeline_Export(t *testing.T) {
+	previous, err := Parse([]byte("stages: [{rate: {per: 1s}}]"))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	next, err := Parse([]byte("stages: [{rate: {per: 1s}}]"))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	other, err := Parse([]byte("stages: [{scale: {factor: 2}}, {rate: {per: 1s}}]"))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	previous.Apply(time.Unix(1000, 0), map[string]int64{"AAPL": 100, "MSFT": 100}, nil)
+
+	state := previous.Export([]string{"AAPL", "GOOG"})
+	if len(state) != 1 || state["AAPL"]["0:rate"].Value != 100 {
+		t.Fatalf("unexpected state: %v", state)
+	}
+	next.Import(state)
+	other.Import(state)
+	if output, _ := next.Apply(time.Unix(1010, 0), map[string]int64{"AAPL": 200}, nil); output["AAPL"] != 10 {
+		t.Fatalf("expected the rate to continue from the imported state, was: %v", output)
+	}
+	if output, _ := other.Apply(time.Unix(1010, 0), map[string]int64{"AAPL": 200}, nil); len(output) != 0 {
+		t.Fatalf("expected state of a different stage to be skipped, was: %v", output)
+	}
+
+	// State held that is newer than the imported state is kept.
+	next.Import(state)
+	if output, _ := next.Apply(time.Unix(1020, 0), map[string]int64{"AAPL": 300}, nil); output["AAPL"] != 10 {
+		t.Fatalf("expected newer state to be kept, was: %v", output)
+	}
+}
+
+func Test_Parse_invalid(t *testing.T) {
+	for _, data := range []string{
+		"stages: [{}]",
+		"stages: [{scale: {factor: 2}, delta: {}}]",
+		"stages: [{include: {regex: '('}}]",
+		"stages: [{rate: {per: 0s}}]",
+		"stages: [{unknown: {}}]",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Fatalf("expected err to be set for %q", data)
+		}
+	}
+}
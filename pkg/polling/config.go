@@ -0,0 +1,188 @@
+package polling
+
+import (
+	"fmt"
+	"gossip/pkg/types"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	yaml "sigs.k8s.io/yaml/goyaml.v2"
+)
+
+const (
+	// DefaultTick is the default base tick at which due entities are polled.
+	DefaultTick = time.Second
+	// DefaultInterval is the default interval for entities that match no rule.
+	DefaultInterval = 10 * time.Second
+)
+
+// Config is a polling configuration, e.g.
+//
+//	tick: 1s
+//	default: 10s
+//	entities:
+//	  AAPL: 1s
+//	groups:
+//	  - name: etfs
+//	    interval: 1m
+//	    match:
+//	      etf: true
+//	  - name: deficient
+//	    interval: 5m
+//	    match:
+//	      financialStatus: [D, E, H]
+//
+// An entity polls at its own interval if it has one, else at the
+// interval of the first group it matches, else at the default interval.
+type Config struct {
+	// Tick is the base tick at which due entities are polled; intervals
+	// are effectively rounded up to a multiple of the tick.
+	Tick     time.Duration            `yaml:"tick"`
+	Default  time.Duration            `yaml:"default"`
+	Entities map[string]time.Duration `yaml:"entities"`
+	Groups   []Group                  `yaml:"groups"`
+}
+
+// Group is a named set of entities that share a polling interval.
+type Group struct {
+	Name     string        `yaml:"name"`
+	Interval time.Duration `yaml:"interval"`
+	Match    Match         `yaml:"match"`
+}
+
+// Match selects entities by their source and data-plane metadata;
+// unset fields match any entity, and set fields must all match.
+type Match struct {
+	// Sources are the names of the sources the entity must be from.
+	Sources []string `yaml:"sources"`
+	// Symbols are glob patterns matched against the entity without its source, e.g. `AA*`.
+	Symbols         []string `yaml:"symbols"`
+	Market          []string `yaml:"market"`
+	FinancialStatus []string `yaml:"financialStatus"`
+	ETF             *bool    `yaml:"etf"`
+	TestIssue       *bool    `yaml:"testIssue"`
+	NextShares      *bool    `yaml:"nextShares"`
+}
+
+// Load reads a polling configuration from a yaml file.
+func Load(path string) (c Config, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	c, err = Parse(data)
+	if err != nil {
+		err = fmt.Errorf("polling: %s: %w", path, err)
+	}
+	return
+}
+
+// Parse parses a yaml polling configuration.
+func Parse(data []byte) (c Config, err error) {
+	if err = yaml.UnmarshalStrict(data, &c); err != nil {
+		return
+	}
+	err = c.Validate()
+	return
+}
+
+// Validate returns an error if any interval is negative, or a symbol pattern is invalid.
+func (c Config) Validate() error {
+	if c.Tick < 0 || c.Default < 0 {
+		return fmt.Errorf("tick and default intervals must not be negative")
+	}
+	for entity, interval := range c.Entities {
+		if interval <= 0 {
+			return fmt.Errorf("entity %s: interval must be positive", entity)
+		}
+	}
+	for index, g := range c.Groups {
+		if g.Interval <= 0 {
+			return fmt.Errorf("group %d (%s): interval must be positive", index, g.Name)
+		}
+		for _, pattern := range g.Match.Symbols {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("group %d (%s): invalid symbol pattern %q: %w", index, g.Name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TickOrDefault returns the base tick or a default.
+func (c Config) TickOrDefault() time.Duration {
+	if c.Tick > 0 {
+		return c.Tick
+	}
+	return DefaultTick
+}
+
+// DefaultOrDefault returns the default interval or a default.
+func (c Config) DefaultOrDefault() time.Duration {
+	if c.Default > 0 {
+		return c.Default
+	}
+	return DefaultInterval
+}
+
+// NeedsInfo returns if any group matches on entity metadata, that is
+// if intervals can't be resolved from the entity names alone.
+func (c Config) NeedsInfo() bool {
+	for _, g := range c.Groups {
+		if g.Match.NeedsInfo() {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsInfo returns if the match depends on entity metadata.
+func (m Match) NeedsInfo() bool {
+	return len(m.Market) > 0 || len(m.FinancialStatus) > 0 || m.ETF != nil || m.TestIssue != nil || m.NextShares != nil
+}
+
+// Interval returns the polling interval for an entity id and the rule that set it,
+// which is the entity id, the group name, or "default".
+func (c Config) Interval(entity string, info types.EntityInfo) (interval time.Duration, rule string) {
+	if interval, ok := c.Entities[entity]; ok {
+		return interval, entity
+	}
+	for _, g := range c.Groups {
+		if g.Match.Matches(entity, info) {
+			return g.Interval, g.Name
+		}
+	}
+	return c.DefaultOrDefault(), "default"
+}
+
+// Matches returns if an entity id matches.
+func (m Match) Matches(id string, info types.EntityInfo) bool {
+	source, entity := types.SplitEntity(id)
+	if len(m.Sources) > 0 && !slices.Contains(m.Sources, source) {
+		return false
+	}
+	if len(m.Symbols) > 0 && !slices.ContainsFunc(m.Symbols, func(pattern string) bool {
+		ok, _ := path.Match(pattern, entity)
+		return ok
+	}) {
+		return false
+	}
+	if len(m.Market) > 0 && !slices.Contains(m.Market, info.Market) {
+		return false
+	}
+	if len(m.FinancialStatus) > 0 && !slices.Contains(m.FinancialStatus, info.FinancialStatus) {
+		return false
+	}
+	if m.ETF != nil && *m.ETF != info.ETF {
+		return false
+	}
+	if m.TestIssue != nil && *m.TestIssue != info.TestIssue {
+		return false
+	}
+	if m.NextShares != nil && *m.NextShares != info.NextShares {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package polling
+
+import (
+	"gossip/pkg/types"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+tick: 1s
+default: 10s
+entities:
+  AAPL: 1s
+groups:
+  - name: etfs
+    interval: 1m
+    match:
+      etf: true
+  - name: deficient
+    interval: 5m
+    match:
+      financialStatus: [D, E]
+  - name: units
+    interval: 30s
+    match:
+      symbols: ["*U"]
+  - name: crypto
+    interval: 2s
+    match:
+      sources: [crypto]
+`
+
+func Test_Config_Interval(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if !c.NeedsInfo() {
+		t.Fatalf("expected config to need entity info")
+	}
+	testCases := []struct {
+		Entity   string
+		Info     types.EntityInfo
+		Expected time.Duration
+		Rule     string
+	}{
+		{"AAPL", types.EntityInfo{ETF: true}, time.Second, "AAPL"},
+		{"AADR", types.EntityInfo{ETF: true}, time.Minute, "etfs"},
+		{"AACG", types.EntityInfo{FinancialStatus: "D"}, 5 * time.Minute, "deficient"},
+		{"AACBU", types.EntityInfo{FinancialStatus: "N"}, 30 * time.Second, "units"},
+		{"AAL", types.EntityInfo{FinancialStatus: "N"}, 10 * time.Second, "default"},
+		{"nasdaq/AACBU", types.EntityInfo{FinancialStatus: "N"}, 30 * time.Second, "units"},
+		{"crypto/BTC", types.EntityInfo{}, 2 * time.Second, "crypto"},
+	}
+	for _, tc := range testCases {
+		interval, rule := c.Interval(tc.Entity, tc.Info)
+		if interval != tc.Expected || rule != tc.Rule {
+			t.Fatalf("expected %s to poll at %v (%s), was: %v (%s)", tc.Entity, tc.Expected, tc.Rule, interval, rule)
+		}
+	}
+}
+
+func Test_Parse_invalid(t *testing.T) {
+	for _, data := range []string{
+		"groups: [{name: bad, interval: 0s}]",
+		"entities: {AAPL: -1s}",
+		"groups: [{name: bad, interval: 1s, match: {symbols: ['[']}}]",
+		"unknown: true",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Fatalf("expected err to be set for %q", data)
+		}
+	}
+}
+
+func Test_Queue_Due(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var q Queue
+	q.Sync(map[string]time.Duration{
+		"AAPL": time.Second,
+		"MSFT": 10 * time.Second,
+	}, now)
+
+	if due := q.Due(now); !slices.Equal(due, []string{"AAPL", "MSFT"}) {
+		t.Fatalf("expected every new entity to be due, was: %v", due)
+	}
+	if due := q.Due(now.Add(500 * time.Millisecond)); len(due) != 0 {
+		t.Fatalf("expected no entities to be due, was: %v", due)
+	}
+	if due := q.Due(now.Add(time.Second)); !slices.Equal(due, []string{"AAPL"}) {
+		t.Fatalf("expected AAPL to be due, was: %v", due)
+	}
+
+	// AAPL is far behind, so it's due once and then rescheduled from now.
+	if due := q.Due(now.Add(10 * time.Second)); !slices.Equal(due, []string{"AAPL", "MSFT"}) {
+		t.Fatalf("expected AAPL and MSFT to be due, was: %v", due)
+	}
+	if due := q.Due(now.Add(10500 * time.Millisecond)); len(due) != 0 {
+		t.Fatalf("expected no entities to be due, was: %v", due)
+	}
+}
+
+func Test_Queue_Sync(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var q Queue
+	q.Sync(map[string]time.Duration{"AAPL": time.Minute, "MSFT": time.Minute}, now)
+	_ = q.Due(now)
+
+	q.Sync(map[string]time.Duration{"AAPL": time.Second, "GOOG": time.Minute}, now)
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 entities, was: %d", q.Len())
+	}
+	if due := q.Due(now); !slices.Equal(due, []string{"GOOG"}) {
+		t.Fatalf("expected the new entity to be due, was: %v", due)
+	}
+	if due := q.Due(now.Add(time.Second)); !slices.Equal(due, []string{"AAPL"}) {
+		t.Fatalf("expected the shortened interval to be brought forward, was: %v", due)
+	}
+}
@@ -0,0 +1,137 @@
+package polling
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is an entity in the queue.
+type Entry struct {
+	Entity   string        `json:"entity"`
+	Interval time.Duration `json:"interval"`
+	Due      time.Time     `json:"due"`
+
+	index int
+}
+
+// Queue is a priority queue of entities ordered by when they are next due.
+//
+// Calling methods on `Queue` is safe to do concurrently.
+type Queue struct {
+	mu      sync.Mutex
+	entries entryHeap
+	byName  map[string]*Entry
+}
+
+// Len returns the number of entities in the queue.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Sync makes the queue hold exactly the given entities with the given intervals.
+//
+// Entities new to the queue are due immediately; entities already in the queue
+// keep their due time, brought forward if their interval was shortened.
+func (q *Queue) Sync(intervals map[string]time.Duration, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.byName == nil {
+		q.byName = make(map[string]*Entry, len(intervals))
+	}
+	for entity, e := range q.byName {
+		if _, ok := intervals[entity]; !ok {
+			heap.Remove(&q.entries, e.index)
+			delete(q.byName, entity)
+		}
+	}
+	for entity, interval := range intervals {
+		e, ok := q.byName[entity]
+		if !ok {
+			e = &Entry{Entity: entity, Interval: interval, Due: now}
+			heap.Push(&q.entries, e)
+			q.byName[entity] = e
+			continue
+		}
+		if interval == e.Interval {
+			continue
+		}
+		if latest := now.Add(interval); e.Due.After(latest) {
+			e.Due = latest
+		}
+		e.Interval = interval
+		heap.Fix(&q.entries, e.index)
+	}
+}
+
+// Due removes and returns the entities due by a given time in the order they
+// were due, and schedules each again one interval after it was due.
+//
+// An entity that has fallen more than an interval behind is scheduled
+// one interval from `by` instead, so it isn't polled repeatedly to catch up.
+func (q *Queue) Due(by time.Time) (entities []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.entries) > 0 && !q.entries[0].Due.After(by) {
+		e := q.entries[0]
+		entities = append(entities, e.Entity)
+		e.Due = e.Due.Add(e.Interval)
+		if !e.Due.After(by) {
+			e.Due = by.Add(e.Interval)
+		}
+		heap.Fix(&q.entries, 0)
+	}
+	return
+}
+
+// Entries returns a copy of the queue entries ordered by due time.
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	output := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		output = append(output, *e)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Due.Equal(output[j].Due) {
+			return output[i].Entity < output[j].Entity
+		}
+		return output[i].Due.Before(output[j].Due)
+	})
+	return output
+}
+
+// entryHeap implements heap.Interface ordered by due time.
+type entryHeap []*Entry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	if h[i].Due.Equal(h[j].Due) {
+		return h[i].Entity < h[j].Entity
+	}
+	return h[i].Due.Before(h[j].Due)
+}
+
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x any) {
+	e := x.(*Entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
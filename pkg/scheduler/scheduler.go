@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is the default interval between ticks.
+const DefaultInterval = 10 * time.Second
+
+// Tick is a scheduled tick.
+type Tick struct {
+	// Slot is the aligned time the tick is scheduled for.
+	Slot time.Time
+	// At is the slot plus jitter, that is when the tick should start.
+	At time.Time
+	// Skipped is the number of slots missed since the previous
+	// tick, i.e. because the previous tick overran the interval.
+	Skipped int
+}
+
+// Scheduler schedules ticks at a fixed interval aligned to the wall clock,
+// shifted by a phase offset and a random jitter.
+//
+// Nodes with synchronized clocks and the same interval tick at the same slots,
+// so giving each node a distinct phase spreads their ticks across the interval
+// instead of bursting whenever the nodes happened to start.
+type Scheduler struct {
+	Interval time.Duration
+	// Jitter is the maximum random delay added to each tick.
+	Jitter time.Duration
+
+	mu    sync.Mutex
+	phase time.Duration
+	last  time.Time
+}
+
+// IntervalOrDefault returns the interval or a default.
+func (s *Scheduler) IntervalOrDefault() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+	return DefaultInterval
+}
+
+// Phase returns the phase offset of ticks within the interval.
+func (s *Scheduler) Phase() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.phase
+}
+
+// SetPhase sets the phase offset of ticks within the interval, taking
+// effect from the next tick; it is reduced modulo the interval.
+func (s *Scheduler) SetPhase(phase time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase % s.IntervalOrDefault()
+	if s.phase < 0 {
+		s.phase += s.IntervalOrDefault()
+	}
+}
+
+// Next returns the next tick after a given time, and records
+// its slot so the following call can detect skipped slots.
+//
+// Slots closer than half an interval to the previous slot are passed
+// over, so a phase change can't cause two ticks in quick succession.
+func (s *Scheduler) Next(now time.Time) (tick Tick) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval := s.IntervalOrDefault()
+	tick.Slot = nextSlot(now, interval, s.phase)
+	if !s.last.IsZero() {
+		if tick.Slot.Sub(s.last) < interval/2 {
+			tick.Slot = tick.Slot.Add(interval)
+		}
+		tick.Skipped = max(int(tick.Slot.Sub(s.last)/interval)-1, 0)
+	}
+	s.last = tick.Slot
+	tick.At = tick.Slot
+	if s.Jitter > 0 {
+		tick.At = tick.At.Add(rand.N(s.Jitter))
+	}
+	return
+}
+
+// Offset returns the phase offset for the node at a given
+// index of `count` nodes, spreading the nodes evenly across the interval.
+func Offset(interval time.Duration, index, count int) time.Duration {
+	if count <= 0 || index < 0 {
+		return 0
+	}
+	return time.Duration(int64(interval) * int64(index%count) / int64(count))
+}
+
+// nextSlot returns the first time strictly after `now` that is `phase`
+// past a multiple of the interval since the unix epoch.
+func nextSlot(now time.Time, interval, phase time.Duration) time.Time {
+	base := now.Add(-phase).Truncate(interval)
+	return base.Add(interval + phase)
+}
@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Scheduler_Next_alignsToPhase(t *testing.T) {
+	s := &Scheduler{Interval: 10 * time.Second}
+	s.SetPhase(3 * time.Second)
+	now := time.Unix(1000, 0)
+
+	tick := s.Next(now)
+	if expected := time.Unix(1003, 0); !tick.Slot.Equal(expected) {
+		t.Fatalf("expected slot %v, was: %v", expected, tick.Slot)
+	}
+	if !tick.At.Equal(tick.Slot) {
+		t.Fatalf("expected no jitter, was: %v", tick.At.Sub(tick.Slot))
+	}
+	if tick.Skipped != 0 {
+		t.Fatalf("expected no skipped slots, was: %d", tick.Skipped)
+	}
+
+	tick = s.Next(time.Unix(1003, 0))
+	if expected := time.Unix(1013, 0); !tick.Slot.Equal(expected) {
+		t.Fatalf("expected slot %v, was: %v", expected, tick.Slot)
+	}
+}
+
+func Test_Scheduler_Next_detectsOverrun(t *testing.T) {
+	s := &Scheduler{Interval: 10 * time.Second}
+	_ = s.Next(time.Unix(1000, 0))
+
+	// the tick for slot 1010 ran until 1035, missing the 1020 and 1030 slots.
+	tick := s.Next(time.Unix(1035, 0))
+	if expected := time.Unix(1040, 0); !tick.Slot.Equal(expected) {
+		t.Fatalf("expected slot %v, was: %v", expected, tick.Slot)
+	}
+	if tick.Skipped != 2 {
+		t.Fatalf("expected 2 skipped slots, was: %d", tick.Skipped)
+	}
+}
+
+func Test_Scheduler_Next_phaseChangeKeepsSpacing(t *testing.T) {
+	s := &Scheduler{Interval: 10 * time.Second}
+	_ = s.Next(time.Unix(1000, 0))
+	s.SetPhase(2 * time.Second)
+
+	tick := s.Next(time.Unix(1010, 0))
+	if expected := time.Unix(1022, 0); !tick.Slot.Equal(expected) {
+		t.Fatalf("expected slot %v, was: %v", expected, tick.Slot)
+	}
+}
+
+func Test_Scheduler_Next_jitter(t *testing.T) {
+	s := &Scheduler{Interval: 10 * time.Second, Jitter: time.Second}
+	for x := 0; x < 100; x++ {
+		tick := s.Next(time.Unix(int64(1000+10*x), 0))
+		if jitter := tick.At.Sub(tick.Slot); jitter < 0 || jitter >= time.Second {
+			t.Fatalf("expected jitter in [0, 1s), was: %v", jitter)
+		}
+	}
+}
+
+func Test_Offset(t *testing.T) {
+	testCases := []struct {
+		Index, Count int
+		Expected     time.Duration
+	}{
+		{0, 4, 0},
+		{1, 4, 2500 * time.Millisecond},
+		{3, 4, 7500 * time.Millisecond},
+		{0, 0, 0},
+		{-1, 4, 0},
+	}
+	for _, tc := range testCases {
+		if actual := Offset(10*time.Second, tc.Index, tc.Count); actual != tc.Expected {
+			t.Fatalf("expected offset(%d, %d) to be %v, was: %v", tc.Index, tc.Count, tc.Expected, actual)
+		}
+	}
+}
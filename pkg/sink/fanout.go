@@ -0,0 +1,210 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"gossip/pkg/types"
+	"gossip/pkg/upstream"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the default number of submissions queued per destination.
+	DefaultQueueSize = 1024
+	// DefaultWriteTimeout is the default timeout for a single write attempt.
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// Destination is a named sink.
+type Destination struct {
+	Name string
+	Sink Sink
+}
+
+// FanoutOptions are options for a fan-out.
+type FanoutOptions struct {
+	// QueueSize is the number of submissions queued per destination;
+	// once a queue is full further submissions to it are dropped.
+	QueueSize int
+	// WriteTimeout is the timeout for a single write attempt.
+	WriteTimeout time.Duration
+	// Retry governs how failed writes are retried.
+	Retry upstream.RetryPolicy
+	// Observer, if set, is called after every write with the final result.
+	Observer func(destination string, elapsed time.Duration, err error)
+	// OnDrop, if set, is called when a submission is dropped for a destination.
+	OnDrop func(destination string)
+}
+
+// QueueSizeOrDefault returns the queue size or a default.
+func (fo FanoutOptions) QueueSizeOrDefault() int {
+	if fo.QueueSize > 0 {
+		return fo.QueueSize
+	}
+	return DefaultQueueSize
+}
+
+// WriteTimeoutOrDefault returns the write timeout or a default.
+func (fo FanoutOptions) WriteTimeoutOrDefault() time.Duration {
+	if fo.WriteTimeout > 0 {
+		return fo.WriteTimeout
+	}
+	return DefaultWriteTimeout
+}
+
+// Stats are the statistics of a fan-out destination.
+type Stats struct {
+	Name    string `json:"name"`
+	Queued  int    `json:"queued"`
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Fanout writes submissions to several destinations, each with its own
+// queue and writer, so a slow or failing destination never blocks the others.
+type Fanout struct {
+	opts         FanoutOptions
+	destinations []*destination
+	wg           sync.WaitGroup
+	cancel       context.CancelFunc
+}
+
+// destination is a fan-out destination and its queue.
+type destination struct {
+	Destination
+	queue   chan record
+	written atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// record is a queued submission.
+type record struct {
+	at         time.Time
+	submission types.MetricSinkSubmission
+}
+
+// NewFanout returns a fan-out to the given destinations and starts its writers.
+func NewFanout(opts FanoutOptions, destinations ...Destination) *Fanout {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Fanout{opts: opts, cancel: cancel}
+	for _, d := range destinations {
+		dest := &destination{
+			Destination: d,
+			queue:       make(chan record, opts.QueueSizeOrDefault()),
+		}
+		f.destinations = append(f.destinations, dest)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.run(ctx, dest)
+		}()
+	}
+	return f
+}
+
+// Len returns the number of destinations.
+func (f *Fanout) Len() int {
+	return len(f.destinations)
+}
+
+// Write queues a submission for every destination without blocking.
+func (f *Fanout) Write(at time.Time, submission types.MetricSinkSubmission) {
+	for _, d := range f.destinations {
+		select {
+		case d.queue <- record{at: at, submission: submission}:
+		default:
+			f.drop(d)
+		}
+	}
+}
+
+// Stats returns the statistics of every destination.
+func (f *Fanout) Stats() (output []Stats) {
+	for _, d := range f.destinations {
+		output = append(output, Stats{
+			Name:    d.Name,
+			Queued:  len(d.queue),
+			Written: d.written.Load(),
+			Failed:  d.failed.Load(),
+			Dropped: d.dropped.Load(),
+		})
+	}
+	return
+}
+
+// Close stops accepting submissions and waits for the queues to drain until
+// the context is done, at which point pending writes are abandoned, then
+// closes every sink; `Write` must not be called after `Close`.
+func (f *Fanout) Close(ctx context.Context) error {
+	for _, d := range f.destinations {
+		close(d.queue)
+	}
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		f.cancel()
+		<-done
+	}
+	f.cancel()
+	var errs []error
+	for _, d := range f.destinations {
+		errs = append(errs, d.Sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// run writes a destination's queued submissions in order until the queue is closed.
+func (f *Fanout) run(ctx context.Context, d *destination) {
+	for r := range d.queue {
+		if ctx.Err() != nil {
+			f.drop(d)
+			continue
+		}
+		started := time.Now()
+		err := f.write(ctx, d, r)
+		if err != nil {
+			d.failed.Add(1)
+		} else {
+			d.written.Add(1)
+		}
+		if f.opts.Observer != nil {
+			f.opts.Observer(d.Name, time.Since(started), err)
+		}
+	}
+}
+
+func (f *Fanout) drop(d *destination) {
+	d.dropped.Add(1)
+	if f.opts.OnDrop != nil {
+		f.opts.OnDrop(d.Name)
+	}
+}
+
+// write writes a submission, retrying failures with backoff.
+func (f *Fanout) write(ctx context.Context, d *destination, r record) (err error) {
+	maxAttempts := f.opts.Retry.MaxAttemptsOrDefault()
+	for attempt := 1; ; attempt++ {
+		attemptContext, cancel := context.WithTimeout(ctx, f.opts.WriteTimeoutOrDefault())
+		err = d.Sink.Write(attemptContext, r.at, r.submission)
+		cancel()
+		if err == nil || attempt >= maxAttempts {
+			return
+		}
+		timer := time.NewTimer(f.opts.Retry.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
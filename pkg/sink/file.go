@@ -0,0 +1,136 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gossip/pkg/types"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFileMaxBytes is the default size at which a file sink rotates.
+	DefaultFileMaxBytes = 64 << 20
+	// DefaultFileMaxFiles is the default number of rotated files kept.
+	DefaultFileMaxFiles = 4
+)
+
+// FileOptions are options for a file sink.
+type FileOptions struct {
+	// MaxBytes is the size at which the file is rotated.
+	MaxBytes int64
+	// MaxFiles is the number of rotated files kept, named `path.1` (newest) to `path.N`.
+	MaxFiles int
+}
+
+// MaxBytesOrDefault returns the max bytes or a default.
+func (fo FileOptions) MaxBytesOrDefault() int64 {
+	if fo.MaxBytes > 0 {
+		return fo.MaxBytes
+	}
+	return DefaultFileMaxBytes
+}
+
+// MaxFilesOrDefault returns the max files or a default.
+func (fo FileOptions) MaxFilesOrDefault() int {
+	if fo.MaxFiles > 0 {
+		return fo.MaxFiles
+	}
+	return DefaultFileMaxFiles
+}
+
+// FileRecord is a line written by a file sink.
+type FileRecord struct {
+	Time      time.Time        `json:"time"`
+	Entity    string           `json:"entity"`
+	Hostname  string           `json:"hostname"`
+	Value     int64            `json:"value"`
+	Aggregate *types.Aggregate `json:"aggregate,omitempty"`
+}
+
+// File writes values as newline delimited json, rotating the file by size.
+type File struct {
+	path string
+	opts FileOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFile opens a file sink, appending to the file if it exists.
+func NewFile(path string, opts FileOptions) (*File, error) {
+	fs := &File{path: path, opts: opts}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Write implements Sink.
+func (fs *File) Write(_ context.Context, at time.Time, submission types.MetricSinkSubmission) error {
+	var data []byte
+	for _, v := range submission.Values {
+		line, err := json.Marshal(FileRecord{
+			Time:      at.UTC(),
+			Entity:    v.Entity,
+			Hostname:  v.Hostname,
+			Value:     v.Value,
+			Aggregate: v.Aggregate,
+		})
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.size > 0 && fs.size+int64(len(data)) > fs.opts.MaxBytesOrDefault() {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := fs.f.Write(data)
+	fs.size += int64(n)
+	return err
+}
+
+// Close implements Sink.
+func (fs *File) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.f.Close()
+}
+
+func (fs *File) open() error {
+	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	fs.f = f
+	fs.size = info.Size()
+	return nil
+}
+
+// rotate shifts `path.N-1` to `path.N` down to `path` to `path.1`, then opens a new file.
+func (fs *File) rotate() error {
+	if err := fs.f.Close(); err != nil {
+		return err
+	}
+	for index := fs.opts.MaxFilesOrDefault() - 1; index > 0; index-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", fs.path, index), fmt.Sprintf("%s.%d", fs.path, index+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(fs.path, fs.path+".1"); err != nil {
+		return err
+	}
+	return fs.open()
+}
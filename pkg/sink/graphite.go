@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"gossip/pkg/types"
+	"net"
+	"sync"
+	"time"
+)
+
+// Graphite writes values in the graphite plaintext protocol over tcp,
+// as `prefix.entity value timestamp`.
+//
+// The connection is dialed on the first write and redialed after any failure.
+type Graphite struct {
+	addr   string
+	prefix string
+	dialer net.Dialer
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewGraphite returns a graphite sink for a `host:port` address.
+func NewGraphite(addr, prefix string) *Graphite {
+	return &Graphite{addr: addr, prefix: prefix}
+}
+
+// Write implements Sink.
+func (g *Graphite) Write(ctx context.Context, at time.Time, submission types.MetricSinkSubmission) (err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		if g.conn, err = g.dialer.DialContext(ctx, "tcp", g.addr); err != nil {
+			g.conn = nil
+			return
+		}
+	}
+	defer func() {
+		if err != nil {
+			_ = g.conn.Close()
+			g.conn = nil
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = g.conn.SetWriteDeadline(deadline)
+	}
+	bw := bufio.NewWriter(g.conn)
+	for _, v := range submission.Values {
+		if _, err = fmt.Fprintf(bw, "%s %d %d\n", join(g.prefix, sanitize(v.Entity)), v.Value, at.Unix()); err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
+
+// Close implements Sink.
+func (g *Graphite) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"gossip/pkg/types"
+	"gossip/pkg/upstream"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Influx writes values in the influxdb line protocol over http, as
+//
+//	measurement,entity=AAPL,hostname=worker-0 value=123i[,min=1i,max=200i,count=3i] timestamp
+//
+// with the timestamp in nanoseconds.
+type Influx struct {
+	URL         string
+	Measurement string
+	Client      *upstream.Client
+}
+
+// NewInflux returns an influx sink for an `influx` or `influxs` url; the `measurement`
+// and `token-file` query parameters are consumed, and the rest are passed through.
+func NewInflux(u *url.URL, query url.Values) (*Influx, error) {
+	measurement := query.Get("measurement")
+	if measurement == "" {
+		measurement = "gossip"
+	}
+	header := http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}}
+	if tokenFile := query.Get("token-file"); tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("sink: reading influx token: %w", err)
+		}
+		header.Set("Authorization", "Token "+strings.TrimSpace(string(token)))
+	}
+	query.Del("measurement")
+	query.Del("token-file")
+	query.Set("precision", "ns")
+
+	target := *u
+	target.Scheme = "http"
+	if u.Scheme == "influxs" {
+		target.Scheme = "https"
+	}
+	target.RawQuery = query.Encode()
+	return &Influx{
+		URL:         target.String(),
+		Measurement: measurement,
+		Client: &upstream.Client{
+			Name:   "influx",
+			Retry:  upstream.RetryPolicy{MaxAttempts: 1},
+			Header: header,
+		},
+	}, nil
+}
+
+// Write implements Sink.
+func (i *Influx) Write(ctx context.Context, at time.Time, submission types.MetricSinkSubmission) error {
+	var sb strings.Builder
+	for _, v := range submission.Values {
+		sb.WriteString(i.line(at, v))
+		sb.WriteByte('\n')
+	}
+	_, err := i.Client.Do(ctx, http.MethodPost, i.URL, []byte(sb.String()), nil)
+	return err
+}
+
+// line returns the line protocol line for a value.
+func (i *Influx) line(at time.Time, v types.MetricSinkSubmissionValue) string {
+	var sb strings.Builder
+	sb.WriteString(influxEscaper.Replace(i.Measurement))
+	sb.WriteString(",entity=")
+	sb.WriteString(influxEscaper.Replace(v.Entity))
+	sb.WriteString(",hostname=")
+	sb.WriteString(influxEscaper.Replace(v.Hostname))
+	sb.WriteString(" value=")
+	sb.WriteString(strconv.FormatInt(v.Value, 10))
+	sb.WriteByte('i')
+	if a := v.Aggregate; a != nil {
+		fmt.Fprintf(&sb, ",min=%di,max=%di,count=%di", a.Min, a.Max, a.Count)
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(at.UnixNano(), 10))
+	return sb.String()
+}
+
+// Close implements Sink.
+func (i *Influx) Close() error { return nil }
+
+// influxEscaper escapes measurement names and tag keys and values.
+var influxEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
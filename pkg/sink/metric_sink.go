@@ -0,0 +1,35 @@
+package sink
+
+import (
+	"context"
+	"gossip/pkg/types"
+	"gossip/pkg/upstream"
+	"strings"
+	"time"
+)
+
+// MetricSink posts submissions to a metric-sink.
+type MetricSink struct {
+	URL    string
+	Client *upstream.Client
+}
+
+// NewMetricSink returns a metric-sink sink for a base url; it makes a single
+// attempt per write, leaving retries to the fan-out.
+func NewMetricSink(baseURL string) *MetricSink {
+	return &MetricSink{
+		URL: strings.TrimSuffix(baseURL, "/") + "/submit",
+		Client: &upstream.Client{
+			Name:  "metric-sink",
+			Retry: upstream.RetryPolicy{MaxAttempts: 1},
+		},
+	}
+}
+
+// Write implements Sink.
+func (ms *MetricSink) Write(ctx context.Context, _ time.Time, submission types.MetricSinkSubmission) error {
+	return ms.Client.PostJSON(ctx, ms.URL, submission, nil)
+}
+
+// Close implements Sink.
+func (ms *MetricSink) Close() error { return nil }
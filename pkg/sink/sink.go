@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"gossip/pkg/types"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sink writes submissions to a destination.
+type Sink interface {
+	// Write writes a submission whose values were fetched at a given time.
+	Write(ctx context.Context, at time.Time, submission types.MetricSinkSubmission) error
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// Parse returns a sink for a url, selected by scheme:
+//
+//	http://metric-sink:3000                      metric-sink submissions
+//	file:///var/lib/gossip/values.ndjson         newline delimited json, with `max-bytes` and `max-files` rotation parameters
+//	statsd://localhost:8125?prefix=gossip        statsd gauges over udp
+//	dogstatsd://localhost:8125?prefix=gossip     dogstatsd gauges with entity and hostname tags over udp
+//	graphite://graphite:2003?prefix=gossip       graphite plaintext over tcp
+//	influx://influxdb:8086/api/v2/write?org=o&bucket=b&measurement=gossip&token-file=/path
+//
+// `https` and `influxs` use tls.
+func Parse(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("sink: invalid url: %w", err)
+	}
+	query := u.Query()
+	switch u.Scheme {
+	case "http", "https":
+		return NewMetricSink(u.String()), nil
+	case "file":
+		return NewFile(u.Path, FileOptions{
+			MaxBytes: queryInt64(query, "max-bytes", DefaultFileMaxBytes),
+			MaxFiles: int(queryInt64(query, "max-files", DefaultFileMaxFiles)),
+		})
+	case "statsd", "dogstatsd":
+		return NewStatsD(u.Host, query.Get("prefix"), u.Scheme == "dogstatsd")
+	case "graphite":
+		return NewGraphite(u.Host, query.Get("prefix")), nil
+	case "influx", "influxs":
+		return NewInflux(u, query)
+	default:
+		return nil, fmt.Errorf("sink: unsupported scheme %q", u.Scheme)
+	}
+}
+
+// Redact returns a sink url without credentials or query parameters, suitable for logs and labels.
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// sanitize replaces the characters in a metric path
+// component that aren't alphanumeric, `_` or `-` with `_`.
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, value)
+}
+
+// join joins non-empty metric path components with dots.
+func join(components ...string) string {
+	var output []string
+	for _, c := range components {
+		if c != "" {
+			output = append(output, c)
+		}
+	}
+	return strings.Join(output, ".")
+}
+
+func queryInt64(query url.Values, key string, defaultValue int64) int64 {
+	var value int64
+	if _, err := fmt.Sscan(query.Get(key), &value); err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
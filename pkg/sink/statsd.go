@@ -0,0 +1,73 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"gossip/pkg/types"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxDatagramSize is the largest statsd datagram written, which
+// stays under the common 1500 byte mtu after ip and udp headers.
+const maxDatagramSize = 1432
+
+// StatsD writes values as statsd gauges over udp.
+//
+// Plain statsd gauges are named `prefix.entity`; dogstatsd gauges are
+// named `prefix.value` and tagged with the entity and hostname.
+type StatsD struct {
+	prefix string
+	tagged bool
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewStatsD returns a statsd sink for a `host:port` address.
+func NewStatsD(addr, prefix string, tagged bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{prefix: prefix, tagged: tagged, conn: conn}, nil
+}
+
+// Write implements Sink.
+func (s *StatsD) Write(_ context.Context, _ time.Time, submission types.MetricSinkSubmission) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var datagram []byte
+	for _, v := range submission.Values {
+		line := s.format(v)
+		if len(datagram) > 0 && len(datagram)+1+len(line) > maxDatagramSize {
+			if _, err := s.conn.Write(datagram); err != nil {
+				return err
+			}
+			datagram = datagram[:0]
+		}
+		if len(datagram) > 0 {
+			datagram = append(datagram, '\n')
+		}
+		datagram = append(datagram, line...)
+	}
+	if len(datagram) == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(datagram)
+	return err
+}
+
+// format returns the statsd gauge line for a value.
+func (s *StatsD) format(v types.MetricSinkSubmissionValue) string {
+	if s.tagged {
+		return fmt.Sprintf("%s:%d|g|#entity:%s,hostname:%s", join(s.prefix, "value"), v.Value, v.Entity, v.Hostname)
+	}
+	return fmt.Sprintf("%s:%d|g", join(s.prefix, sanitize(v.Entity)), v.Value)
+}
+
+// Close implements Sink.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
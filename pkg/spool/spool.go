@@ -0,0 +1,500 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentMaxBytes is the default size at which a new segment is started.
+	DefaultSegmentMaxBytes = 4 << 20
+	// DefaultMaxBytes is the default maximum size of all segments.
+	DefaultMaxBytes = 256 << 20
+	// DefaultMaxAge is the default maximum age of a segment.
+	DefaultMaxAge = 24 * time.Hour
+
+	segmentSuffix = ".seg"
+	cursorFile    = "cursor"
+	headerSize    = 8
+)
+
+var (
+	// ErrCorrupt is returned when a record fails its checksum.
+	ErrCorrupt = errors.New("spool: corrupt record")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Options are the options for a spool.
+type Options struct {
+	SegmentMaxBytes int64
+	MaxBytes        int64
+	MaxAge          time.Duration
+}
+
+// SegmentMaxBytesOrDefault returns the segment max bytes or a default.
+func (o Options) SegmentMaxBytesOrDefault() int64 {
+	if o.SegmentMaxBytes > 0 {
+		return o.SegmentMaxBytes
+	}
+	return DefaultSegmentMaxBytes
+}
+
+// MaxBytesOrDefault returns the max bytes or a default.
+func (o Options) MaxBytesOrDefault() int64 {
+	if o.MaxBytes > 0 {
+		return o.MaxBytes
+	}
+	return DefaultMaxBytes
+}
+
+// MaxAgeOrDefault returns the max age or a default.
+func (o Options) MaxAgeOrDefault() time.Duration {
+	if o.MaxAge > 0 {
+		return o.MaxAge
+	}
+	return DefaultMaxAge
+}
+
+// Stats are point in time statistics for a spool.
+type Stats struct {
+	Segments int
+	Bytes    int64
+	Records  int
+	// Dropped is the number of records dropped by the size and age caps since open.
+	Dropped uint64
+	// Corrupt is the number of records skipped because they failed their checksum since open.
+	Corrupt uint64
+}
+
+// Spool is a durable, ordered queue of records stored as segment files in a directory.
+//
+// Each record is written as:
+//
+//	[length:4][crc32c:4][payload]
+//
+// with integers in big endian order. Records are appended to the newest segment
+// and replayed from the oldest; a cursor file records progress within the oldest
+// segment so a replay interrupted by a failure resumes where it left off. Fully
+// replayed segments are deleted.
+//
+// Replay is at-least-once; a crash during replay may deliver records again.
+type Spool struct {
+	dir  string
+	opts Options
+	now  func() time.Time
+
+	// replayMu serializes replays, which only hold mu between records.
+	replayMu sync.Mutex
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	nextID   uint64
+	cursor   cursor
+	dropped  uint64
+	corrupt  uint64
+}
+
+// segment is a spool segment file.
+type segment struct {
+	id      uint64
+	size    int64
+	records int
+	modTime time.Time
+}
+
+// cursor is the replay position within a segment.
+type cursor struct {
+	segment uint64
+	offset  int64
+}
+
+// Open opens or creates a spool in a given directory.
+func Open(dir string, opts Options) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:    dir,
+		opts:   opts,
+		now:    time.Now,
+		nextID: 1,
+	}
+	var err error
+	if s.cursor, err = s.readCursor(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		id, ok := parseSegmentName(entry.Name())
+		if !ok {
+			continue
+		}
+		if id < s.cursor.segment {
+			// a replayed segment we failed to delete.
+			_ = os.Remove(s.segmentPath(id))
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		seg := &segment{id: id, size: info.Size(), modTime: info.ModTime()}
+		if seg.records, err = s.countRecords(seg); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.nextID = max(s.nextID, id+1)
+	}
+	s.nextID = max(s.nextID, s.cursor.segment)
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+	return s, nil
+}
+
+// Append durably appends a record to the spool.
+func (s *Spool) Append(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordSize := int64(headerSize + len(payload))
+	if recordSize > s.opts.MaxBytesOrDefault() {
+		return fmt.Errorf("spool: record size %d exceeds max bytes %d", recordSize, s.opts.MaxBytesOrDefault())
+	}
+	if s.active == nil || s.segments[len(s.segments)-1].size+recordSize > s.opts.SegmentMaxBytesOrDefault() {
+		if err := s.rotateUnsafe(); err != nil {
+			return err
+		}
+	}
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	seg := s.segments[len(s.segments)-1]
+	seg.size += recordSize
+	seg.records++
+	seg.modTime = s.now()
+	s.enforceUnsafe()
+	return nil
+}
+
+// Replay calls a given function for each record in order, removing records
+// as they are delivered, until the spool is empty or the function returns an error.
+//
+// The payload passed to the function is only valid for the duration of the call.
+//
+// The function is called without holding the spool's lock, such that records
+// can be appended and statistics read while it pushes; records appended
+// after the replay started are left for the next replay.
+func (s *Spool) Replay(fn func(payload []byte) error) (replayed int, err error) {
+	return s.ReplayN(0, fn)
+}
+
+// ReplayN replays as `Replay` does, but stops after a given number of
+// records, if the number is positive, leaving the rest for the next replay.
+func (s *Spool) ReplayN(n int, fn func(payload []byte) error) (replayed int, err error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	s.enforceUnsafe()
+	if err = s.sealUnsafe(); err != nil {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.segments) == 0 {
+		err = s.writeCursor(cursor{segment: s.nextID})
+		s.mu.Unlock()
+		return
+	}
+	// segments up to the last one are sealed, since appends start a new segment.
+	last := s.segments[len(s.segments)-1].id
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 || s.segments[0].id > last {
+			err = s.writeCursor(s.headUnsafe())
+			s.mu.Unlock()
+			return
+		}
+		seg := s.segments[0]
+		var offset int64
+		if s.cursor.segment == seg.id {
+			offset = s.cursor.offset
+		}
+		s.mu.Unlock()
+
+		limit := 0
+		if n > 0 {
+			limit = n - replayed
+		}
+		var segmentReplayed int
+		var limited bool
+		segmentReplayed, offset, limited, err = s.replaySegment(seg, offset, limit, fn)
+		replayed += segmentReplayed
+
+		s.mu.Lock()
+		if len(s.segments) == 0 || s.segments[0] != seg {
+			// the size or age caps dropped the segment while it was replayed.
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil || limited {
+			if saveErr := s.writeCursor(cursor{segment: seg.id, offset: offset}); saveErr != nil {
+				err = errors.Join(err, saveErr)
+			}
+			s.mu.Unlock()
+			return
+		}
+		err = os.Remove(s.segmentPath(seg.id))
+		if err == nil {
+			s.segments = s.segments[1:]
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// headUnsafe returns the cursor at the start of the oldest segment, or
+// of the next segment to be created if there are none.
+func (s *Spool) headUnsafe() cursor {
+	if len(s.segments) > 0 {
+		return cursor{segment: s.segments[0].id}
+	}
+	return cursor{segment: s.nextID}
+}
+
+// Stats returns statistics for the spool.
+func (s *Spool) Stats() (stats Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.Segments = len(s.segments)
+	for _, seg := range s.segments {
+		stats.Bytes += seg.size
+		stats.Records += seg.records
+	}
+	stats.Dropped = s.dropped
+	stats.Corrupt = s.corrupt
+	return
+}
+
+// Close closes the active segment.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sealUnsafe()
+}
+
+// replaySegment delivers the records in a sealed segment from a given offset,
+// returning the offset after the last delivered record. It stops early if
+// the segment is dropped by the caps while it's replayed, or once a positive
+// limit of records is delivered, in which case `limited` is set.
+func (s *Spool) replaySegment(seg *segment, offset int64, limit int, fn func([]byte) error) (replayed int, nextOffset int64, limited bool, err error) {
+	nextOffset = offset
+	f, err := os.Open(s.segmentPath(seg.id))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	br := bufio.NewReader(f)
+	for {
+		if limit > 0 && replayed == limit {
+			limited = true
+			return
+		}
+		payload, readErr := readRecord(br, s.opts.MaxBytesOrDefault())
+		if readErr == io.EOF {
+			return
+		}
+		if readErr != nil {
+			// skip the rest of the segment; a torn or corrupt record means we can't find the next boundary.
+			s.mu.Lock()
+			s.corrupt += uint64(seg.records)
+			seg.records = 0
+			s.mu.Unlock()
+			return
+		}
+		if err = fn(payload); err != nil {
+			return
+		}
+		replayed++
+		nextOffset += int64(headerSize + len(payload))
+		s.mu.Lock()
+		seg.records--
+		dropped := len(s.segments) == 0 || s.segments[0] != seg
+		s.mu.Unlock()
+		if dropped {
+			return
+		}
+	}
+}
+
+// rotateUnsafe seals the active segment and starts a new one.
+func (s *Spool) rotateUnsafe() error {
+	if err := s.sealUnsafe(); err != nil {
+		return err
+	}
+	id := s.nextID
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.nextID++
+	s.active = f
+	s.segments = append(s.segments, &segment{id: id, modTime: s.now()})
+	return nil
+}
+
+// sealUnsafe closes the active segment so no more records are appended to it.
+func (s *Spool) sealUnsafe() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
+
+// enforceUnsafe drops the oldest sealed segments that exceed the age or size caps.
+func (s *Spool) enforceUnsafe() {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	now := s.now()
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		if s.active != nil && len(s.segments) == 1 {
+			return
+		}
+		if total <= s.opts.MaxBytesOrDefault() && now.Sub(seg.modTime) <= s.opts.MaxAgeOrDefault() {
+			return
+		}
+		_ = os.Remove(s.segmentPath(seg.id))
+		s.dropped += uint64(seg.records)
+		total -= seg.size
+		s.segments = s.segments[1:]
+	}
+}
+
+// countRecords counts the valid records in a segment from the cursor.
+func (s *Spool) countRecords(seg *segment) (count int, err error) {
+	f, err := os.Open(s.segmentPath(seg.id))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if s.cursor.segment == seg.id {
+		if _, err = f.Seek(s.cursor.offset, io.SeekStart); err != nil {
+			return
+		}
+	}
+	br := bufio.NewReader(f)
+	for {
+		if _, readErr := readRecord(br, s.opts.MaxBytesOrDefault()); readErr != nil {
+			return
+		}
+		count++
+	}
+}
+
+func (s *Spool) readCursor() (c cursor, err error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	if len(data) != 16 {
+		err = fmt.Errorf("spool: invalid cursor file length %d", len(data))
+		return
+	}
+	c.segment = binary.BigEndian.Uint64(data[0:8])
+	c.offset = int64(binary.BigEndian.Uint64(data[8:16]))
+	return
+}
+
+// writeCursor atomically replaces the cursor file.
+func (s *Spool) writeCursor(c cursor) error {
+	s.cursor = c
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data[0:8], c.segment)
+	binary.BigEndian.PutUint64(data[8:16], uint64(c.offset))
+	tempPath := filepath.Join(s.dir, cursorFile+".tmp")
+	if err := os.WriteFile(tempPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, filepath.Join(s.dir, cursorFile))
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentSuffix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+	return id, err == nil
+}
+
+// readRecord reads a single record, returning `io.EOF` at a clean segment end.
+//
+// Records longer than `maxLength` are treated as corrupt so a damaged
+// length can't cause an arbitrarily large allocation.
+func readRecord(br *bufio.Reader, maxLength int64) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrCorrupt
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length) > maxLength {
+		return nil, ErrCorrupt
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, ErrCorrupt
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, ErrCorrupt
+	}
+	return payload, nil
+}
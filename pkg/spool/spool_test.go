@@ -0,0 +1,174 @@
+package spool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Spool_AppendReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{SegmentMaxBytes: 32})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	for _, payload := range []string{"one", "two", "three", "four"} {
+		if err := s.Append([]byte(payload)); err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+	}
+	if stats := s.Stats(); stats.Records != 4 || stats.Segments != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// fail on the second record, which should leave the remaining records spooled.
+	errFailed := errors.New("failed")
+	var replayed []string
+	count, err := s.Replay(func(payload []byte) error {
+		if string(payload) == "two" {
+			return errFailed
+		}
+		replayed = append(replayed, string(payload))
+		return nil
+	})
+	if !errors.Is(err, errFailed) || count != 1 {
+		t.Fatalf("expected one record replayed before failure, was: %d %v", count, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+
+	// reopen and resume from the cursor.
+	s, err = Open(dir, Options{SegmentMaxBytes: 32})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if stats := s.Stats(); stats.Records != 3 {
+		t.Fatalf("expected 3 records after reopen, was: %+v", stats)
+	}
+	if _, err := s.Replay(func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	}); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if len(replayed) != 4 || replayed[0] != "one" || replayed[1] != "two" || replayed[3] != "four" {
+		t.Fatalf("unexpected replay order: %v", replayed)
+	}
+	if stats := s.Stats(); stats.Records != 0 || stats.Segments != 0 {
+		t.Fatalf("expected spool to be empty, was: %+v", stats)
+	}
+}
+
+func Test_Spool_ReplayN(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{SegmentMaxBytes: 32})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	for _, payload := range []string{"one", "two", "three"} {
+		if err := s.Append([]byte(payload)); err != nil {
+			t.Fatalf("expected err to be unset, was: %v", err)
+		}
+	}
+	var replayed []string
+	replay := func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	}
+	if count, err := s.ReplayN(2, replay); err != nil || count != 2 {
+		t.Fatalf("expected 2 records replayed, was: %d %v", count, err)
+	}
+	if stats := s.Stats(); stats.Records != 1 {
+		t.Fatalf("expected 1 record left, was: %+v", stats)
+	}
+	if count, err := s.ReplayN(2, replay); err != nil || count != 1 {
+		t.Fatalf("expected the last record replayed, was: %d %v", count, err)
+	}
+	if len(replayed) != 3 || replayed[2] != "three" {
+		t.Fatalf("unexpected replay order: %v", replayed)
+	}
+}
+
+func Test_Spool_appendDuringReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if err := s.Append([]byte("one")); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	var replayed []string
+	if _, err := s.Replay(func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		// appending and reading stats while a record is pushed must not block on the replay.
+		if stats := s.Stats(); stats.Records != 1 {
+			t.Fatalf("expected the record being replayed to be counted, was: %+v", stats)
+		}
+		return s.Append([]byte("two"))
+	}); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if len(replayed) != 1 {
+		t.Fatalf("expected records appended during a replay to be left for the next, was: %v", replayed)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if _, err := s.Replay(func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	}); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if len(replayed) != 2 || replayed[1] != "two" {
+		t.Fatalf("expected the appended record to survive a reopen, was: %v", replayed)
+	}
+}
+
+func Test_Spool_corruptTail(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, Options{})
+	_ = s.Append([]byte("one"))
+	_ = s.Append([]byte("two"))
+	_ = s.Close()
+
+	// simulate a torn write of the last record.
+	path := filepath.Join(dir, "00000000000000000001.seg")
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	var replayed []string
+	_, err = s.Replay(func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	})
+	if err != nil || len(replayed) != 1 || replayed[0] != "one" {
+		t.Fatalf("expected only the intact record to replay, was: %v %v", replayed, err)
+	}
+}
+
+func Test_Spool_maxAge(t *testing.T) {
+	s, _ := Open(t.TempDir(), Options{SegmentMaxBytes: 16, MaxAge: time.Minute})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	_ = s.Append([]byte("old record"))
+	now = now.Add(2 * time.Minute)
+	_ = s.Append([]byte("new record"))
+	if stats := s.Stats(); stats.Records != 1 || stats.Dropped != 1 {
+		t.Fatalf("expected the old segment to be dropped, was: %+v", stats)
+	}
+}
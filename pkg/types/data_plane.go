@@ -3,3 +3,18 @@ package types
 type DataPlaneResponse struct {
 	Entities map[string]int64
 }
+
+// EntityInfo is the reference data the data-plane holds for an entity,
+// as listed in the nasdaq symbol directory.
+type EntityInfo struct {
+	Symbol string
+	Name   string
+	// Market is the market tier, e.g. `Q` for the global select market.
+	Market    string
+	TestIssue bool
+	// FinancialStatus is the financial status, e.g. `N` for normal or `D` for deficient.
+	FinancialStatus string
+	RoundLot        int
+	ETF             bool
+	NextShares      bool
+}
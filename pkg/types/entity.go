@@ -0,0 +1,21 @@
+package types
+
+import "strings"
+
+// QualifyEntity returns the id of an entity from a named source, that is
+// `source/entity`, or the entity itself if the source is unnamed.
+func QualifyEntity(source, entity string) string {
+	if source == "" {
+		return entity
+	}
+	return source + "/" + entity
+}
+
+// SplitEntity splits an entity id into its source and entity.
+func SplitEntity(id string) (source, entity string) {
+	source, entity, ok := strings.Cut(id, "/")
+	if !ok {
+		return "", id
+	}
+	return
+}
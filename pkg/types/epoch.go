@@ -0,0 +1,31 @@
+package types
+
+// Epoch identifies the view of the ring a writer used to decide it owned an entity.
+//
+// memberlist does not expose incarnation numbers, so the worker derives the view
+// from a hybrid logical clock the nodes gossip, which it advances whenever its
+// ring fingerprint or takeovers change, breaking ties with a counter of its own
+// metadata updates. A writer that changes its view after hearing of another
+// writer's view always has the newer epoch, whatever their wall clocks say.
+type Epoch struct {
+	// View is the epoch clock's view when the writer first observed the ring
+	// and the takeovers it pushes with, roughly a unix time in milliseconds.
+	View int64 `json:"view"`
+	// Incarnation is the number of times the writer has re-advertised its metadata.
+	Incarnation uint32 `json:"incarnation"`
+	// Ring is the fingerprint of the ring.
+	Ring uint64 `json:"ring"`
+}
+
+// IsZero returns if the epoch is unset.
+func (e Epoch) IsZero() bool {
+	return e == Epoch{}
+}
+
+// Less returns if the epoch is older than another epoch.
+func (e Epoch) Less(other Epoch) bool {
+	if e.View != other.View {
+		return e.View < other.View
+	}
+	return e.Incarnation < other.Incarnation
+}
@@ -1,11 +1,58 @@
 package types
 
 type MetricSinkSubmission struct {
-	Values []MetricSinkSubmissionValue
+	// IdempotencyKey identifies the submission across retries, such that
+	// the metric-sink applies a repeated submission at most once.
+	IdempotencyKey string `json:",omitempty"`
+	Values         []MetricSinkSubmissionValue
 }
 
 type MetricSinkSubmissionValue struct {
 	Entity   string
 	Hostname string
 	Value    int64
+	Epoch    Epoch
+	// Aggregate, if set, summarizes the values fetched over an
+	// aggregation window, and `Value` is the last of them.
+	Aggregate *Aggregate `json:",omitempty"`
+}
+
+// MetricSinkSubmitResponse is the metric-sink response to a submission.
+type MetricSinkSubmitResponse struct {
+	Accepted int
+	// Rejected are values fenced off because a different writer
+	// has submitted the entity with a newer epoch.
+	Rejected []MetricSinkRejectedValue
+	// Conflicts are entities accepted from a different writer with
+	// the same ring fingerprint, i.e. split ownership.
+	Conflicts []string
+	// Duplicate is set if the submission repeats an idempotency key the
+	// metric-sink has already applied, in which case the response is the original result.
+	Duplicate bool `json:",omitempty"`
+}
+
+// MetricSinkRejectedValue is a value rejected by the metric-sink.
+type MetricSinkRejectedValue struct {
+	Entity string
+	Reason string
+}
+
+// Aggregate summarizes the values fetched for an entity over an aggregation window.
+type Aggregate struct {
+	Min   int64
+	Max   int64
+	Last  int64
+	Count int
+}
+
+// Add adds a value to the aggregate.
+func (a *Aggregate) Add(value int64) {
+	if a.Count == 0 || value < a.Min {
+		a.Min = value
+	}
+	if a.Count == 0 || value > a.Max {
+		a.Max = value
+	}
+	a.Last = value
+	a.Count++
 }
@@ -0,0 +1,159 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailureThreshold is the default number of consecutive
+	// failures before a breaker opens.
+	DefaultFailureThreshold = 5
+	// DefaultOpenDuration is the default time a breaker stays open
+	// before allowing a trial request.
+	DefaultOpenDuration = 30 * time.Second
+)
+
+var (
+	// ErrCircuitOpen is returned when a request is rejected by an open breaker.
+	ErrCircuitOpen = errors.New("upstream: circuit breaker is open")
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+// BreakerState values.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// String returns a string form of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is a consecutive failure circuit breaker.
+//
+// A closed breaker allows all requests. After `FailureThreshold` consecutive
+// failures the breaker opens and rejects requests for `OpenDuration`, after
+// which it is half-open and allows a single trial request; if the trial
+// succeeds the breaker closes, otherwise it opens again.
+type Breaker struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+	// OnStateChange is called, with the breaker lock held, when the state changes.
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+// FailureThresholdOrDefault returns the failure threshold or a default.
+func (b *Breaker) FailureThresholdOrDefault() int {
+	if b.FailureThreshold > 0 {
+		return b.FailureThreshold
+	}
+	return DefaultFailureThreshold
+}
+
+// OpenDurationOrDefault returns the open duration or a default.
+func (b *Breaker) OpenDurationOrDefault() time.Duration {
+	if b.OpenDuration > 0 {
+		return b.OpenDuration
+	}
+	return DefaultOpenDuration
+}
+
+// State returns the current state.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceUnsafe()
+	return b.state
+}
+
+// Allow returns `ErrCircuitOpen` if a request should not be attempted.
+//
+// Every allowed request must be followed by a call to `Record` or `Cancel`.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceUnsafe()
+	switch b.state {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Record records the outcome of an allowed request.
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		b.failures = 0
+		b.trial = false
+		b.setStateUnsafe(BreakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.FailureThresholdOrDefault() {
+		b.trial = false
+		b.openedAt = b.nowUnsafe()
+		b.setStateUnsafe(BreakerOpen)
+	}
+}
+
+// Cancel records that the caller abandoned an allowed request, which says
+// nothing about the upstream; a half-open breaker allows another trial request.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// advanceUnsafe moves an open breaker to half-open once the open duration elapses.
+func (b *Breaker) advanceUnsafe() {
+	if b.state == BreakerOpen && b.nowUnsafe().Sub(b.openedAt) >= b.OpenDurationOrDefault() {
+		b.setStateUnsafe(BreakerHalfOpen)
+	}
+}
+
+func (b *Breaker) setStateUnsafe(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, state)
+	}
+}
+
+func (b *Breaker) nowUnsafe() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,247 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultAttemptTimeout is the default timeout for a single attempt.
+	DefaultAttemptTimeout = 5 * time.Second
+)
+
+// StatusError is returned for responses with an unsuccessful status code.
+type StatusError struct {
+	Upstream   string
+	StatusCode int
+	Body       string
+}
+
+// Error implements error.
+func (se *StatusError) Error() string {
+	if se.Body != "" {
+		return fmt.Sprintf("upstream %s: unexpected status %d: %s", se.Upstream, se.StatusCode, se.Body)
+	}
+	return fmt.Sprintf("upstream %s: unexpected status %d", se.Upstream, se.StatusCode)
+}
+
+// Retryable returns if the status indicates a transient failure.
+func (se *StatusError) Retryable() bool {
+	return RetryableStatus(se.StatusCode)
+}
+
+// RetryableStatus returns if a status code indicates a transient failure
+// that is worth retrying; other 4xx and 5xx statuses are permanent.
+func RetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooEarly,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// Response is a fully read upstream response.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Client makes requests to a single named upstream with per-attempt
+// timeouts, retries with backoff, and an optional circuit breaker.
+//
+// Request bodies are buffered so they can be resent on retries, and
+// response bodies are read fully so connections are always released.
+type Client struct {
+	Name           string
+	HTTP           *http.Client
+	Retry          RetryPolicy
+	AttemptTimeout time.Duration
+	Breaker        *Breaker
+	// Header is added to every request.
+	Header http.Header
+	// Observer, if set, is called after every attempt.
+	Observer func(upstream string, elapsed time.Duration, statusCode int, err error)
+}
+
+// HTTPOrDefault returns the http client or a default.
+func (c *Client) HTTPOrDefault() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+// AttemptTimeoutOrDefault returns the attempt timeout or a default.
+func (c *Client) AttemptTimeoutOrDefault() time.Duration {
+	if c.AttemptTimeout > 0 {
+		return c.AttemptTimeout
+	}
+	return DefaultAttemptTimeout
+}
+
+// GetJSON gets a url and decodes the json response into a given value.
+func (c *Client) GetJSON(ctx context.Context, url string, v any) error {
+	res, err := c.Do(ctx, http.MethodGet, url, nil, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(res.Body, v)
+}
+
+// PostJSON posts a value as json to a url, and if `v` is set, decodes the json response into it.
+func (c *Client) PostJSON(ctx context.Context, url string, body, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	res, err := c.Do(ctx, http.MethodPost, url, data, header)
+	if err != nil {
+		return err
+	}
+	if v == nil || len(res.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(res.Body, v)
+}
+
+// Do performs a request, retrying transient failures until the attempts
+// are exhausted, a permanent failure occurs, or the context is done.
+//
+// A `Retry-After` header on a retryable response overrides the backoff; if
+// the requested delay would exceed the context deadline the call fails early.
+func (c *Client) Do(ctx context.Context, method, url string, body []byte, header http.Header) (res *Response, err error) {
+	maxAttempts := c.Retry.MaxAttemptsOrDefault()
+	for attempt := 1; ; attempt++ {
+		res, err = c.attempt(ctx, method, url, body, header)
+		if err == nil || attempt >= maxAttempts || !retryable(err) || ctx.Err() != nil {
+			return
+		}
+		delay := c.Retry.Backoff(attempt)
+		if res != nil {
+			if retryAfter, ok := RetryAfter(res.Header, time.Now()); ok {
+				delay = retryAfter
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			err = fmt.Errorf("upstream %s: retry delay %v exceeds deadline: %w", c.Name, delay, err)
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = errors.Join(err, ctx.Err())
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt performs a single request attempt through the breaker.
+func (c *Client) attempt(ctx context.Context, method, url string, body []byte, header http.Header) (res *Response, err error) {
+	if c.Breaker != nil {
+		if err = c.Breaker.Allow(); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil && ctx.Err() != nil {
+				// the caller gave up on the request, so the failure isn't the upstream's;
+				// attempt timeouts still count, as they derive from the attempt context.
+				c.Breaker.Cancel()
+				return
+			}
+			// permanent failures indicate a bad request, not an unhealthy upstream.
+			c.Breaker.Record(err == nil || !retryable(err))
+		}()
+	}
+	started := time.Now()
+	defer func() {
+		if c.Observer != nil {
+			var statusCode int
+			if res != nil {
+				statusCode = res.StatusCode
+			}
+			c.Observer(c.Name, time.Since(started), statusCode, err)
+		}
+	}()
+
+	attemptContext, cancel := context.WithTimeout(ctx, c.AttemptTimeoutOrDefault())
+	defer cancel()
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(attemptContext, method, url, bodyReader)
+	if err != nil {
+		return
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	httpRes, err := c.HTTPOrDefault().Do(req)
+	if err != nil {
+		err = &transientError{err}
+		return
+	}
+	defer httpRes.Body.Close()
+	res = &Response{
+		StatusCode: httpRes.StatusCode,
+		Header:     httpRes.Header,
+	}
+	res.Body, err = io.ReadAll(httpRes.Body)
+	if err != nil {
+		err = &transientError{err}
+		return
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		err = &StatusError{
+			Upstream:   c.Name,
+			StatusCode: res.StatusCode,
+			Body:       string(bytes.TrimSpace(truncate(res.Body, 256))),
+		}
+	}
+	return
+}
+
+// transientError wraps network and transport failures, which are retryable.
+type transientError struct {
+	err error
+}
+
+func (te *transientError) Error() string { return te.err.Error() }
+func (te *transientError) Unwrap() error { return te.err }
+
+// retryable returns if an attempt error is worth retrying.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+	var te *transientError
+	return errors.As(err, &te)
+}
+
+func truncate(data []byte, length int) []byte {
+	if len(data) > length {
+		return data[:length]
+	}
+	return data
+}
@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Client_Do_retriesTransientStatus(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) < 3 {
+			rw.Header().Set("Retry-After", "0")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(`["AAPL"]`))
+	}))
+	defer server.Close()
+
+	c := &Client{Name: "test", Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	var entities []string
+	if err := c.GetJSON(context.Background(), server.URL, &entities); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, was: %d", calls.Load())
+	}
+	if len(entities) != 1 || entities[0] != "AAPL" {
+		t.Fatalf("unexpected entities: %v", entities)
+	}
+}
+
+func Test_Client_Do_permanentStatus(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		http.Error(rw, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	c := &Client{Name: "test", Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	_, err := c.Do(context.Background(), http.MethodGet, server.URL, nil, nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a 400 status error, was: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 call, was: %d", calls.Load())
+	}
+}
+
+func Test_Breaker(t *testing.T) {
+	now := time.Now()
+	b := &Breaker{FailureThreshold: 2, OpenDuration: time.Minute, now: func() time.Time { return now }}
+	for x := 0; x < 2; x++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected breaker to allow request %d, was: %v", x, err)
+		}
+		b.Record(false)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected breaker to be open, was: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected breaker to allow a trial request, was: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected breaker to allow only one trial request, was: %v", err)
+	}
+	b.Record(true)
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("expected breaker to be closed, was: %v", state)
+	}
+}
+
+func Test_Client_Do_breakerIgnoresCallerCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	b := &Breaker{FailureThreshold: 1}
+	c := &Client{Name: "test", Retry: RetryPolicy{MaxAttempts: 1}, AttemptTimeout: time.Minute, Breaker: b}
+	for _, cause := range []string{"canceled", "deadline"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if cause == "canceled" {
+			time.AfterFunc(10*time.Millisecond, cancel)
+		}
+		_, err := c.Do(ctx, http.MethodGet, server.URL, nil, nil)
+		cancel()
+		if err == nil {
+			t.Fatalf("expected err to be set for a %s caller context", cause)
+		}
+		if state := b.State(); state != BreakerClosed {
+			t.Fatalf("expected a %s caller context not to count as a failure, breaker was: %v", cause, state)
+		}
+	}
+
+	c.AttemptTimeout = 20 * time.Millisecond
+	if _, err := c.Do(context.Background(), http.MethodGet, server.URL, nil, nil); err == nil {
+		t.Fatalf("expected err to be set")
+	}
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("expected an attempt timeout to count as a failure, breaker was: %v", state)
+	}
+}
+
+func Test_Breaker_Cancel(t *testing.T) {
+	now := time.Now()
+	b := &Breaker{FailureThreshold: 1, OpenDuration: time.Minute, now: func() time.Time { return now }}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	b.Record(false)
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected breaker to allow a trial request, was: %v", err)
+	}
+	b.Cancel()
+	if state := b.State(); state != BreakerHalfOpen {
+		t.Fatalf("expected a cancelled trial to leave the breaker half-open, was: %v", state)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected breaker to allow another trial request, was: %v", err)
+	}
+}
+
+func Test_RetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	delay, ok := RetryAfter(http.Header{"Retry-After": []string{"7"}}, now)
+	if !ok || delay != 7*time.Second {
+		t.Fatalf("expected 7s, was: %v %v", delay, ok)
+	}
+	delay, ok = RetryAfter(http.Header{"Retry-After": []string{now.Add(time.Minute).Format(http.TimeFormat)}}, now)
+	if !ok || delay != time.Minute {
+		t.Fatalf("expected 1m, was: %v %v", delay, ok)
+	}
+	if _, ok = RetryAfter(http.Header{}, now); ok {
+		t.Fatalf("expected unset header to be invalid")
+	}
+}
@@ -0,0 +1,80 @@
+package upstream
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of attempts per call.
+	DefaultMaxAttempts = 3
+	// DefaultBaseDelay is the default delay before the first retry.
+	DefaultBaseDelay = 100 * time.Millisecond
+	// DefaultMaxDelay is the default maximum delay between attempts.
+	DefaultMaxDelay = 5 * time.Second
+)
+
+// RetryPolicy governs how failed attempts are retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// MaxAttemptsOrDefault returns the maximum attempts or a default.
+func (rp RetryPolicy) MaxAttemptsOrDefault() int {
+	if rp.MaxAttempts > 0 {
+		return rp.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+// BaseDelayOrDefault returns the base delay or a default.
+func (rp RetryPolicy) BaseDelayOrDefault() time.Duration {
+	if rp.BaseDelay > 0 {
+		return rp.BaseDelay
+	}
+	return DefaultBaseDelay
+}
+
+// MaxDelayOrDefault returns the max delay or a default.
+func (rp RetryPolicy) MaxDelayOrDefault() time.Duration {
+	if rp.MaxDelay > 0 {
+		return rp.MaxDelay
+	}
+	return DefaultMaxDelay
+}
+
+// Backoff returns the delay before a given retry (starting at 1) using
+// exponential backoff with full jitter, that is a random duration
+// between zero and `min(MaxDelay, BaseDelay * 2^(retry-1))`.
+func (rp RetryPolicy) Backoff(retry int) time.Duration {
+	ceiling := rp.MaxDelayOrDefault()
+	if shift := retry - 1; shift < 32 {
+		if delay := rp.BaseDelayOrDefault() << shift; delay > 0 && delay < ceiling {
+			ceiling = delay
+		}
+	}
+	return rand.N(ceiling) + 1
+}
+
+// RetryAfter parses a `Retry-After` header, given either in
+// seconds or as an http date, returning false if it is unset or invalid.
+func RetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
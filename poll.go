@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gossip/pkg/types"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// refreshEntities returns the union of every source's entity list, and if
+// any list changed, or entity metadata was fetched, since the last call.
+//
+// The node elected as a source's list fetcher fetches the list at most once per
+// interval, or sooner if a refresh-entities event asks to, and shares it with
+// the cluster; the other nodes use the shared list, and only fetch the list
+// themselves once it goes stale. If a fetch fails the last list is used, and
+// the list is fetched again on the next tick.
+func (w *worker) refreshEntities(ctx context.Context, now time.Time) (entities []string, listed bool) {
+	// allow for jitter when the tick and the interval are the same.
+	due := w.refreshRequested.Swap(false) || w.listedAt.IsZero() || now.Sub(w.listedAt) >= *interval-w.scheduler.IntervalOrDefault()/2
+	if w.listVersions == nil {
+		w.listVersions = make(map[string]uint64, len(w.sources))
+	}
+	ring := w.currentRing()
+	failed := false
+	for _, s := range w.sources {
+		fetcher := w.listFetcher(ring, s) == w.hostname
+		l, ok := w.lists.Get(s.Name)
+		stale := !ok || now.Sub(l.FetchedAt) >= listStaleOrDefault()
+		if due && (fetcher || stale) {
+			if err := w.fetchEntityList(ctx, s, fetcher); err != nil {
+				slog.Error("failed to get entities", slog.String("hostname", w.hostname), slog.String("source", s.Name), slog.Any("err", err))
+				failed = true
+			} else if fetcher {
+				w.metrics.entityListRefreshes.Inc("fetched")
+			} else {
+				w.metrics.entityListRefreshes.Inc("fallback")
+			}
+			l, _ = w.lists.Get(s.Name)
+		}
+		if l.Version != w.listVersions[s.Name] {
+			w.listVersions[s.Name] = l.Version
+			listed = true
+		}
+		entities = append(entities, l.Entities...)
+	}
+	if due && !failed {
+		w.listedAt = now
+	}
+	// metadata that failed to fetch is retried every tick, not only when a list changes.
+	if (listed || w.infosPending) && w.needsInfo() {
+		fetched, err := w.refreshInfos(ctx, entities)
+		if err != nil {
+			slog.Error("failed to get entity info", slog.String("hostname", w.hostname), slog.Any("err", err))
+		}
+		w.infosPending = err != nil
+		// entities given metadata may move to other poll groups.
+		listed = listed || fetched > 0
+	}
+	return
+}
+
+// refreshInfos fetches the data-plane metadata for entities it hasn't seen yet,
+// returning the number of entities it fetched metadata for.
+func (w *worker) refreshInfos(ctx context.Context, entities []string) (fetched int, err error) {
+	if w.infos == nil {
+		w.infos = make(map[string]types.EntityInfo, len(entities))
+	}
+	var missing []string
+	for _, e := range entities {
+		if _, ok := w.infos[e]; !ok {
+			missing = append(missing, e)
+		}
+	}
+	groups, err := w.bySource(missing)
+	if err != nil {
+		return 0, err
+	}
+	for s, entities := range groups {
+		for len(entities) > 0 {
+			n := min(max(*batchSize, 1), len(entities))
+			u, err := url.Parse(s.URL + "/meta")
+			if err != nil {
+				return fetched, err
+			}
+			u.RawQuery = fmt.Sprintf("s=%s", strings.Join(entities[:n], ","))
+			var infos map[string]types.EntityInfo
+			if err := s.Client.GetJSON(ctx, u.String(), &infos); err != nil {
+				return fetched, err
+			}
+			for _, e := range entities[:n] {
+				// entities without metadata are recorded so they aren't requested again.
+				w.infos[types.QualifyEntity(s.Name, e)] = infos[e]
+			}
+			fetched += n
+			entities = entities[n:]
+		}
+	}
+	return fetched, nil
+}
+
+// syncPolls updates the poll queue to hold the entities owned in a ring,
+// handing off the state of entities that moved to other nodes.
+func (w *worker) syncPolls(ring ringState, now time.Time) {
+	w.handOff(ring)
+	intervals := make(map[string]time.Duration, len(ring.Owned))
+	for _, e := range ring.Owned {
+		intervals[e], _ = w.pollConfig.Interval(e, w.infos[e])
+	}
+	w.polls.Sync(intervals, now)
+	w.pushState.Retain(ring.Owned)
+	w.pipeline.Retain(ring.Owned)
+	w.polledOwned = ring.Owned
+	w.polledFingerprint = ring.Fingerprint
+	w.polledTakenOver = ring.TakenOver
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gossip/pkg/events"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Queries the worker answers.
+const (
+	// queryOwner asks for the owners of the comma separated entity ids
+	// in the payload, per each node's ring.
+	queryOwner = "owner"
+	// queryFingerprint asks for each node's ring fingerprint.
+	queryFingerprint = "fingerprint"
+)
+
+// newQueries returns the queries with the worker's handlers registered.
+func (w *worker) newQueries() *events.Queries {
+	qs := events.NewQueries(w.hostname, events.QueryOptions{
+		Options: events.Options{
+			NumNodes: w.numPeers,
+			Prefix:   []byte{messageQuery},
+		},
+		ResponsePrefix: []byte{messageQueryResponse},
+		Respond:        w.sendTo,
+	})
+	qs.Handle(queryOwner, func(q events.Query) ([]byte, error) {
+		ring := w.currentRing()
+		if ring.Ring == nil {
+			return nil, errors.New("ring not built")
+		}
+		owners := make(map[string]string)
+		for _, e := range strings.Split(string(q.Payload), ",") {
+			if e != "" {
+				owners[e] = ring.Ring.Assignment(e)
+			}
+		}
+		return json.Marshal(owners)
+	})
+	qs.Handle(queryFingerprint, func(events.Query) ([]byte, error) {
+		return json.Marshal(w.currentRing().Fingerprint)
+	})
+	return qs
+}
+
+// sendTo sends a message directly to a member by name.
+func (w *worker) sendTo(name string, msg []byte) error {
+	addr, ok := w.peerAddress(name)
+	if !ok {
+		return fmt.Errorf("unknown member %q", name)
+	}
+	return w.list.SendToAddress(memberlist.Address{Addr: addr, Name: name}, msg)
+}
+
+// receiveQuery answers a query sent by another node.
+func (w *worker) receiveQuery(msg []byte) {
+	q, err := w.queries.Receive(msg)
+	switch {
+	case errors.Is(err, events.ErrDuplicate):
+		w.metrics.queries.Inc("duplicate")
+	case errors.Is(err, events.ErrStale):
+		w.metrics.queries.Inc("stale")
+	case err != nil:
+		w.metrics.queries.Inc("failed")
+		slog.Error("failed to answer query", slog.String("hostname", w.hostname), slog.String("name", q.Name), slog.String("origin", q.Origin), slog.Any("err", err))
+	default:
+		w.metrics.queries.Inc("answered")
+	}
+}
+
+// receiveQueryResponse collects a response to a query sent by this node.
+func (w *worker) receiveQueryResponse(msg []byte) {
+	r, err := w.queries.ReceiveResponse(msg)
+	if err != nil && !errors.Is(err, events.ErrDuplicate) {
+		slog.Warn("dropped query response", slog.String("hostname", w.hostname), slog.String("from", r.From), slog.Any("err", err))
+	}
+}
+
+// queryResult is the response to a query sent from the admin server.
+type queryResult struct {
+	Name      string          `json:"name"`
+	Responses []queryResponse `json:"responses"`
+	// Missing are the members that didn't respond in time.
+	Missing []string `json:"missing,omitempty"`
+	// Distinct is the number of distinct payloads in successful responses,
+	// so that anything but one is a disagreement between nodes.
+	Distinct int `json:"distinct"`
+}
+
+// queryResponse is a node's response, with json payloads inlined.
+type queryResponse struct {
+	From    string          `json:"from"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// registerQueryHandlers adds the query control endpoint.
+func (w *worker) registerQueryHandlers(control *http.ServeMux) {
+	control.HandleFunc("POST /admin/queries/{name}", func(rw http.ResponseWriter, req *http.Request) {
+		payload, err := io.ReadAll(io.LimitReader(req.Body, events.MaxPayloadSize+1))
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("failed to read payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		params := events.QueryParams{Nodes: req.URL.Query()["node"]}
+		if value := req.URL.Query().Get("timeout"); value != "" {
+			if params.Timeout, err = time.ParseDuration(value); err != nil {
+				http.Error(rw, fmt.Sprintf("invalid timeout: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		name := req.PathValue("name")
+		responses, err := w.queries.Ask(req.Context(), name, payload, params)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(rw, http.StatusOK, w.newQueryResult(name, params.Nodes, responses))
+	})
+}
+
+// newQueryResult summarizes the responses to a query.
+func (w *worker) newQueryResult(name string, nodes []string, responses []events.Response) queryResult {
+	result := queryResult{Name: name}
+	if len(nodes) == 0 {
+		nodes = w.peerNames()
+	}
+	var payloads [][]byte
+	for _, r := range responses {
+		qr := queryResponse{From: r.From, Error: r.Error}
+		if json.Valid(r.Payload) {
+			qr.Payload = r.Payload
+		} else if r.Payload != nil {
+			qr.Payload, _ = json.Marshal(r.Payload)
+		}
+		result.Responses = append(result.Responses, qr)
+		if r.Error == "" && !slices.ContainsFunc(payloads, func(p []byte) bool { return bytes.Equal(p, r.Payload) }) {
+			payloads = append(payloads, r.Payload)
+		}
+	}
+	for _, n := range nodes {
+		if !slices.ContainsFunc(responses, func(r events.Response) bool { return r.From == n }) {
+			result.Missing = append(result.Missing, n)
+		}
+	}
+	result.Distinct = len(payloads)
+	return result
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"gossip/pkg/consistenthash"
+	"gossip/pkg/scheduler"
+	"gossip/pkg/types"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ringState is the hashring built from the current membership along
+// with the entities this node was assigned from it.
+type ringState struct {
+	Ring        *consistenthash.ConsistentHash
+	Fingerprint uint64
+	// ChangedAt is when the ring's membership last changed, and EpochView is
+	// the epoch clock's view of this node's last ownership change, which also
+	// includes takeovers starting or ending.
+	ChangedAt time.Time
+	EpochView int64
+	Entities  []string
+	// Owned includes the entities of the stalled members this node has taken over.
+	Owned []string
+	// TakenOver are the stalled members whose entities this node owns as
+	// their second owner, and TakenOverEntities is the number of them.
+	TakenOver         []string
+	TakenOverEntities int
+	// TakenOverBy are the members that say they have taken over this node.
+	TakenOverBy []string
+}
+
+// refreshRing rebuilds the hashring from the current membership,
+// assigns the given entities, and records the result.
+//
+// The entities of a member that stopped completing ticks are assigned
+// to their second owner until the member sends heartbeats again.
+func (w *worker) refreshRing(entities []string) ringState {
+	ch := consistenthash.New()
+	for _, m := range w.getMembers() {
+		ch.AddWeightedBucket(m.Name, m.replicas())
+	}
+	stalled := w.stalledMembers(ch)
+	var owned []string
+	var takenOverEntities int
+	takenOver := make(map[string]struct{})
+	for _, e := range entities {
+		if len(stalled) == 0 {
+			if ch.Assignment(e) == w.hostname {
+				owned = append(owned, e)
+			}
+			continue
+		}
+		owners := ch.Owners(e, 2)
+		switch {
+		case owners[0] == w.hostname:
+			owned = append(owned, e)
+		case len(owners) == 2 && owners[1] == w.hostname && stalled[owners[0]]:
+			owned = append(owned, e)
+			takenOver[owners[0]] = struct{}{}
+			takenOverEntities++
+		}
+	}
+	takenOverBy := w.heartbeats.TakenOverBy(w.hostname)
+
+	w.ringMu.Lock()
+	defer w.ringMu.Unlock()
+	fingerprint := ch.Fingerprint()
+	changedAt, epochView := w.ring.ChangedAt, w.ring.EpochView
+	standby := slices.Sorted(maps.Keys(takenOver))
+	if w.ring.Ring == nil || fingerprint != w.ring.Fingerprint {
+		changedAt = time.Now()
+		epochView = w.epochClock.Next(changedAt)
+		buckets := ch.Buckets()
+		// spread the members' ticks across the interval by their position in the ring.
+		w.scheduler.SetPhase(scheduler.Offset(w.scheduler.IntervalOrDefault(), slices.Index(buckets, w.hostname), len(buckets)))
+		slog.Info("ring changed", slog.String("hostname", w.hostname), slog.Uint64("fingerprint", fingerprint), slog.Any("buckets", buckets), slog.Duration("phase", w.scheduler.Phase()))
+	} else if !slices.Equal(standby, w.ring.TakenOver) || !slices.Equal(takenOverBy, w.ring.TakenOverBy) {
+		// a takeover or its end starts a new epoch on both sides, such that
+		// the metric-sink accepts the values of whichever node pushes last,
+		// without restarting the readiness settle period of the ring.
+		epochView = w.epochClock.Next(time.Now())
+		slog.Info("standby ownership changed", slog.String("hostname", w.hostname), slog.Any("taken-over", standby), slog.Int("taken-over-entities", takenOverEntities), slog.Any("taken-over-by", takenOverBy))
+	}
+	w.ring = ringState{
+		Ring:              ch,
+		Fingerprint:       fingerprint,
+		ChangedAt:         changedAt,
+		EpochView:         epochView,
+		Entities:          entities,
+		Owned:             owned,
+		TakenOver:         standby,
+		TakenOverEntities: takenOverEntities,
+		TakenOverBy:       takenOverBy,
+	}
+	return w.ring
+}
+
+// epoch returns the ownership epoch for a ring state.
+func (w *worker) epoch(ring ringState) types.Epoch {
+	w.metaMu.Lock()
+	defer w.metaMu.Unlock()
+	return types.Epoch{
+		View:        ring.EpochView,
+		Incarnation: w.incarnation,
+		Ring:        ring.Fingerprint,
+	}
+}
+
+// epochClock is a hybrid logical clock of the views of ownership epochs.
+//
+// Nodes gossip the newest view they created or observed with their
+// heartbeats and push/pull state, and a new view is newer than every view
+// the node observed, such that a node that takes over entities after
+// hearing of their previous owner's epoch always has the newer epoch,
+// however far its wall clock is behind. The wall clock only keeps views
+// increasing across restarts of the whole cluster.
+type epochClock struct {
+	mu   sync.Mutex
+	last int64
+}
+
+// Next returns a new view, newer than every view created or observed.
+func (c *epochClock) Next(now time.Time) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.last+1, now.UnixMilli())
+	return c.last
+}
+
+// Observe advances the clock past a view gossiped by another node.
+func (c *epochClock) Observe(view int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.last, view)
+}
+
+// Now returns the newest view created or observed.
+func (c *epochClock) Now() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// currentRing returns the most recently built ring state.
+func (w *worker) currentRing() ringState {
+	w.ringMu.Lock()
+	defer w.ringMu.Unlock()
+	return w.ring
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_epochClock(t *testing.T) {
+	var behind, ahead epochClock
+	now := time.Unix(1000, 0)
+
+	// the previous owner's clock runs a minute ahead of its successor's.
+	previous := ahead.Next(now.Add(time.Minute))
+	behind.Observe(ahead.Now())
+	first := behind.Next(now)
+	if first <= previous {
+		t.Fatalf("expected a view created after observing another to be newer, was: %d <= %d", first, previous)
+	}
+	if second := behind.Next(now); second <= first {
+		t.Fatalf("expected views to increase, was: %d <= %d", second, first)
+	}
+	later := now.Add(time.Hour)
+	if next := behind.Next(later); next != later.UnixMilli() {
+		t.Fatalf("expected the view to follow the wall clock once it is ahead, was: %d", next)
+	}
+	behind.Observe(1)
+	if behind.Now() != later.UnixMilli() {
+		t.Fatalf("expected observing an older view not to move the clock back, was: %d", behind.Now())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"gossip/pkg/sink"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// stringsFlag is a flag that can be given multiple times.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
+}
+
+var sinkURLs stringsFlag
+
+func init() {
+	flag.Var(&sinkURLs, "sink", "An additional destination for pushed values, given by url, e.g. `file:///data/values.ndjson`, `dogstatsd://localhost:8125` or `graphite://graphite:2003`; can be given multiple times")
+}
+
+// newFanout returns a fan-out to the configured sinks, or nil if there are none.
+func (w *worker) newFanout() (*sink.Fanout, error) {
+	if len(sinkURLs) == 0 {
+		return nil, nil
+	}
+	var destinations []sink.Destination
+	for _, rawURL := range sinkURLs {
+		s, err := sink.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, sink.Destination{Name: sink.Redact(rawURL), Sink: s})
+	}
+	return sink.NewFanout(sink.FanoutOptions{
+		WriteTimeout: *upstreamTimeout,
+		Observer: func(destination string, elapsed time.Duration, err error) {
+			w.metrics.sinkWrites.Inc(destination, result(err))
+			w.metrics.sinkDuration.Observe(elapsed.Seconds(), destination)
+			if err != nil {
+				slog.Error("sink write failed", slog.String("hostname", w.hostname), slog.String("sink", destination), slog.Any("err", err))
+			}
+		},
+		OnDrop: func(destination string) {
+			w.metrics.sinkDropped.Inc(destination)
+		},
+	}, destinations...), nil
+}
+
+// closeFanout waits for queued sink writes to finish, up to a timeout.
+func (w *worker) closeFanout() {
+	if w.fanout == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := w.fanout.Close(ctx); err != nil {
+		slog.Error("failed to close sinks", slog.String("hostname", w.hostname), slog.Any("err", err))
+	}
+}
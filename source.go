@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gossip/pkg/types"
+	"gossip/pkg/upstream"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	sourceSpecs      stringsFlag
+	sourceTokenFiles stringsFlag
+)
+
+func init() {
+	flag.Var(&sourceSpecs, "source", "A named data-plane source as `name=url`, with optional basic auth credentials in the url; can be given multiple times, in which case entities are identified as `name/entity`. Defaults to the unnamed data-plane url")
+	flag.Var(&sourceTokenFiles, "source-token-file", "A file holding the bearer token for a named source as `name=path`; can be given multiple times")
+}
+
+// source is a data-plane the worker polls.
+//
+// Entities from a named source are identified as `name/entity` so the
+// ring shards the union of every source's entities.
+type source struct {
+	Name   string
+	URL    string
+	Client *upstream.Client
+}
+
+// newSources returns the configured sources, or the unnamed data-plane if none are configured.
+func (w *worker) newSources() ([]*source, error) {
+	if len(sourceSpecs) == 0 {
+		return []*source{{URL: *dataPlaneURL, Client: w.newUpstreamClient("data-plane")}}, nil
+	}
+	tokens := make(map[string]string, len(sourceTokenFiles))
+	for _, spec := range sourceTokenFiles {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid source token file %q; expected name=path", spec)
+		}
+		token, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: reading token: %w", name, err)
+		}
+		tokens[name] = strings.TrimSpace(string(token))
+	}
+	var sources []*source
+	for _, spec := range sourceSpecs {
+		name, rawURL, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid source %q; expected name=url with a name without slashes", spec)
+		}
+		if slices.ContainsFunc(sources, func(s *source) bool { return s.Name == name }) {
+			return nil, fmt.Errorf("duplicate source %q", name)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: invalid url: %w", name, err)
+		}
+		client := w.newUpstreamClient("data-plane/" + name)
+		client.Header = make(http.Header)
+		if u.User != nil {
+			password, _ := u.User.Password()
+			client.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)))
+			u.User = nil
+		}
+		if token, ok := tokens[name]; ok {
+			client.Header.Set("Authorization", "Bearer "+token)
+			delete(tokens, name)
+		}
+		sources = append(sources, &source{Name: name, URL: strings.TrimSuffix(u.String(), "/"), Client: client})
+	}
+	if len(tokens) > 0 {
+		return nil, fmt.Errorf("token files given for unknown sources %v", slices.Sorted(maps.Keys(tokens)))
+	}
+	return sources, nil
+}
+
+// source returns the source with a given name, or nil.
+func (w *worker) source(name string) *source {
+	for _, s := range w.sources {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// bySource groups entity ids by their source, in the order the sources are configured,
+// returning the unqualified entities for each source.
+func (w *worker) bySource(ids []string) (groups map[*source][]string, err error) {
+	groups = make(map[*source][]string)
+	for _, id := range ids {
+		name, entity := types.SplitEntity(id)
+		s := w.source(name)
+		if s == nil {
+			return nil, fmt.Errorf("entity %s: unknown source %q", id, name)
+		}
+		groups[s] = append(groups[s], entity)
+	}
+	return
+}
+
+// sortBySource sorts entity ids by source, keeping their order
+// within each source, such that batches rarely span sources.
+func sortBySource(ids []string) []string {
+	output := slices.Clone(ids)
+	slices.SortStableFunc(output, func(a, b string) int {
+		sourceA, _ := types.SplitEntity(a)
+		sourceB, _ := types.SplitEntity(b)
+		return strings.Compare(sourceA, sourceB)
+	})
+	return output
+}
+
+// getEntityList fetches a source's entity list, qualified by the source name.
+//
+// If an etag is given and the list hasn't changed since, the data-plane
+// responds without the list and `notModified` is set.
+func (w *worker) getEntityList(ctx context.Context, s *source, etag string) (entities []string, newETag string, notModified bool, err error) {
+	started := time.Now()
+	slog.Info("getting entity list", slog.String("hostname", w.hostname), slog.String("source", s.Name))
+	defer func() {
+		if err != nil {
+			slog.Error("getting entity list failed", slog.String("hostname", w.hostname), slog.String("source", s.Name), slog.Duration("elapsed", time.Since(started)), slog.Any("err", err))
+		} else {
+			slog.Info("getting entity list success", slog.String("hostname", w.hostname), slog.String("source", s.Name), slog.Duration("elapsed", time.Since(started)))
+		}
+	}()
+	var header http.Header
+	if etag != "" {
+		header = http.Header{"If-None-Match": []string{etag}}
+	}
+	res, err := s.Client.Do(ctx, http.MethodGet, s.URL+"/", nil, header)
+	if err != nil {
+		return
+	}
+	newETag = res.Header.Get("ETag")
+	if res.StatusCode == http.StatusNotModified {
+		notModified = true
+		return
+	}
+	if err = json.Unmarshal(res.Body, &entities); err != nil {
+		return
+	}
+	for index, entity := range entities {
+		entities[index] = types.QualifyEntity(s.Name, entity)
+	}
+	return
+}
+
+func (w *worker) getEntityData(ctx context.Context, ids ...string) (data types.DataPlaneResponse, err error) {
+	started := time.Now()
+	slog.Info("getting entity data", slog.String("hostname", w.hostname))
+	defer func() {
+		if err != nil {
+			slog.Error("getting entity data failed", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)), slog.Any("err", err))
+		} else {
+			slog.Info("getting entity data success", slog.String("hostname", w.hostname), slog.Duration("elapsed", time.Since(started)))
+		}
+	}()
+	groups, err := w.bySource(ids)
+	if err != nil {
+		return
+	}
+	data.Entities = make(map[string]int64, len(ids))
+	for s, entities := range groups {
+		var u *url.URL
+		if u, err = url.Parse(s.URL + "/data"); err != nil {
+			return
+		}
+		u.RawQuery = fmt.Sprintf("s=%s", strings.Join(entities, ","))
+		var part types.DataPlaneResponse
+		if err = s.Client.GetJSON(ctx, u.String(), &part); err != nil {
+			return
+		}
+		for entity, value := range part.Entities {
+			data.Entities[types.QualifyEntity(s.Name, entity)] = value
+		}
+	}
+	return
+}
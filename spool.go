@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gossip/pkg/spool"
+	"gossip/pkg/types"
+	"gossip/pkg/upstream"
+	"log/slog"
+)
+
+// submit pushes a submission to the metric-sink.
+//
+// If a spool is configured and the push fails, or older submissions are still
+// spooled, the submission is spooled instead so submissions reach the sink in order.
+func (w *worker) submit(ctx context.Context, submission types.MetricSinkSubmission) (response types.MetricSinkSubmitResponse, spooled bool, err error) {
+	if w.spool == nil {
+		response, err = w.pushSubmission(ctx, submission)
+		return
+	}
+	if w.spool.Stats().Records == 0 {
+		if response, err = w.pushSubmission(ctx, submission); err == nil || permanent(err) {
+			return
+		}
+	}
+	var payload []byte
+	if payload, err = json.Marshal(submission); err != nil {
+		return
+	}
+	if err = w.spool.Append(payload); err != nil {
+		return
+	}
+	spooled = true
+	return
+}
+
+// replaySpool pushes spooled submissions in order until the spool is empty,
+// a push fails, or a given number of submissions is replayed if positive.
+//
+// Submissions the sink rejects permanently are dropped so they can't block the spool.
+func (w *worker) replaySpool(ctx context.Context, max int) {
+	if w.spool == nil || w.spool.Stats().Records == 0 {
+		return
+	}
+	replayed, err := w.spool.ReplayN(max, func(payload []byte) error {
+		var submission types.MetricSinkSubmission
+		if err := json.Unmarshal(payload, &submission); err != nil {
+			slog.Error("dropping invalid spooled submission", slog.String("hostname", w.hostname), slog.Any("err", err))
+			return nil
+		}
+		_, err := w.pushSubmission(ctx, submission)
+		if err != nil && permanent(err) {
+			slog.Error("dropping spooled submission rejected by metric-sink", slog.String("hostname", w.hostname), slog.Any("err", err))
+			return nil
+		}
+		return err
+	})
+	w.metrics.spoolReplayed.Add(float64(replayed))
+	if err != nil {
+		slog.Error("failed to replay spooled submissions", slog.String("hostname", w.hostname), slog.Int("replayed", replayed), slog.Int("remaining", w.spool.Stats().Records), slog.Any("err", err))
+		return
+	}
+	slog.Info("replayed spooled submissions", slog.String("hostname", w.hostname), slog.Int("replayed", replayed), slog.Int("remaining", w.spool.Stats().Records))
+}
+
+// permanent returns if an error is a rejection that retrying won't fix.
+func permanent(err error) bool {
+	var statusErr *upstream.StatusError
+	return errors.As(err, &statusErr) && !statusErr.Retryable()
+}
+
+// spoolStats returns the spool statistics, or empty statistics if spooling is disabled.
+func (w *worker) spoolStats() spool.Stats {
+	if w.spool == nil {
+		return spool.Stats{}
+	}
+	return w.spool.Stats()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"gossip/pkg/consistenthash"
+	"gossip/pkg/heartbeat"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var standbyMissedHeartbeats = flag.Int("standby-missed-heartbeats", 3, "The number of heartbeat timeouts, the max tick duration plus an interval each, a node may go without completing a tick before the second owner of its entities takes over pushing them; 0 disables standby takeovers")
+
+// newHeartbeats returns the tracker of the heartbeats nodes broadcast after every tick.
+func (w *worker) newHeartbeats() *heartbeat.Tracker {
+	return heartbeat.New(w.hostname, heartbeat.Options{
+		NumNodes: w.numPeers,
+		Prefix:   []byte{messageHeartbeat},
+	})
+}
+
+// heartbeatTimeout returns the longest a node whose ticks complete within
+// the max tick duration goes between heartbeats, which it sends after every
+// tick: the next tick starts in the next slot, up to an interval and the
+// jitter after a tick that took the max tick duration.
+func (w *worker) heartbeatTimeout() time.Duration {
+	return w.maxTickDuration() + w.scheduler.IntervalOrDefault() + w.scheduler.Jitter
+}
+
+// beat broadcasts that this node completed a tick, along
+// with the stalled nodes it has taken over and its epoch view.
+func (w *worker) beat() {
+	if _, err := w.heartbeats.Beat(w.currentRing().TakenOver, w.epochClock.Now()); err != nil {
+		slog.Error("failed to broadcast heartbeat", slog.String("hostname", w.hostname), slog.Any("err", err))
+	}
+}
+
+// receiveHeartbeat records a heartbeat broadcast by another node, and
+// advances the epoch clock past its epoch view.
+func (w *worker) receiveHeartbeat(msg []byte) {
+	h, err := w.heartbeats.Receive(msg)
+	if err != nil && !errors.Is(err, heartbeat.ErrStale) {
+		slog.Error("failed to receive heartbeat", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	w.epochClock.Observe(h.Epoch)
+}
+
+// stalledMembers returns the other members of a ring that missed enough
+// heartbeats for the second owners of their entities to take them over.
+//
+// A stalled node is alive as far as memberlist can tell, such that it
+// stays in the ring, but doesn't complete its ticks. Missed heartbeats are
+// counted in heartbeat timeouts rather than intervals, such that a node in
+// a tick that is slow but within its limit isn't taken over.
+func (w *worker) stalledMembers(ch *consistenthash.ConsistentHash) map[string]bool {
+	if *standbyMissedHeartbeats <= 0 {
+		return nil
+	}
+	buckets := ch.Buckets()
+	w.heartbeats.Retain(buckets)
+	stalled := make(map[string]bool)
+	for _, b := range buckets {
+		if b != w.hostname && w.heartbeats.Missed(b, w.heartbeatTimeout()) >= *standbyMissedHeartbeats {
+			stalled[b] = true
+		}
+	}
+	return stalled
+}
+
+// heartbeatStatus is the debug representation of the last heartbeat seen from a node.
+type heartbeatStatus struct {
+	heartbeat.Seen
+	Missed int `json:"missed"`
+}
+
+// standbyStatus is the debug representation of standby takeovers.
+type standbyStatus struct {
+	Heartbeats map[string]heartbeatStatus `json:"heartbeats"`
+	// TakenOver are the stalled nodes whose entities this node pushes.
+	TakenOver         []string `json:"takenOver"`
+	TakenOverEntities int      `json:"takenOverEntities"`
+	// TakenOverBy are the nodes that say they push this node's entities.
+	TakenOverBy []string `json:"takenOverBy"`
+}
+
+// registerStandbyHandlers adds the standby debug endpoint.
+func (w *worker) registerStandbyHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/standby", func(rw http.ResponseWriter, req *http.Request) {
+		ring := w.currentRing()
+		output := standbyStatus{
+			Heartbeats:        make(map[string]heartbeatStatus),
+			TakenOver:         ring.TakenOver,
+			TakenOverEntities: ring.TakenOverEntities,
+			TakenOverBy:       ring.TakenOverBy,
+		}
+		timeout := w.heartbeatTimeout()
+		for node, s := range w.heartbeats.Seen() {
+			output.Heartbeats[node] = heartbeatStatus{Seen: s, Missed: int(time.Since(s.At) / timeout)}
+		}
+		writeJSON(rw, http.StatusOK, output)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"gossip/pkg/consistenthash"
+	"gossip/pkg/heartbeat"
+	"gossip/pkg/scheduler"
+	"testing"
+	"time"
+)
+
+func Test_stalledMembers(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	w := newTestWorker(t, "a", "")
+	w.scheduler = &scheduler.Scheduler{Interval: time.Second}
+	w.heartbeats = heartbeat.New("a", heartbeat.Options{Prefix: []byte{messageHeartbeat}, Now: clock})
+	b := heartbeat.New("b", heartbeat.Options{Prefix: []byte{messageHeartbeat}, Now: clock})
+	ch := consistenthash.New()
+	ch.AddWeightedBucket("a", 1)
+	ch.AddWeightedBucket("b", 1)
+
+	if _, err := b.Beat(nil, 0); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+	msgs := b.GetBroadcasts(0, 1400)
+	if _, err := w.heartbeats.Receive(msgs[0][1:]); err != nil {
+		t.Fatalf("expected err to be unset, was: %v", err)
+	}
+
+	// b is in a tick that is slow, but within the max tick duration.
+	now = now.Add(w.maxTickDuration())
+	if stalled := w.stalledMembers(ch); len(stalled) != 0 {
+		t.Fatalf("expected a node in a slow tick not to be stalled, was: %v", stalled)
+	}
+	now = now.Add(time.Duration(*standbyMissedHeartbeats) * w.heartbeatTimeout())
+	if stalled := w.stalledMembers(ch); !stalled["b"] || stalled["a"] {
+		t.Fatalf("expected b to be stalled, was: %v", stalled)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gossip/pkg/gossipkv"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Cluster-wide counters.
+const (
+	counterEntitiesFetched = "entities_fetched"
+	counterValuesPushed    = "values_pushed"
+	counterPushFailures    = "push_failures"
+)
+
+// gossipState is the state exchanged with other nodes on memberlist push/pulls.
+type gossipState struct {
+	KV       json.RawMessage `json:"kv,omitempty"`
+	Counters json.RawMessage `json:"counters,omitempty"`
+	Lists    []sharedList    `json:"lists,omitempty"`
+	// Epoch is the sender's epoch view, such that a joining node's first
+	// epoch is newer than the epochs of the nodes it joins.
+	Epoch int64 `json:"epoch,omitempty"`
+}
+
+// LocalState implements memberlist.Delegate and returns the shared state.
+func (w *worker) LocalState(join bool) []byte {
+	var state gossipState
+	var err error
+	if state.KV, err = w.kv.State(); err != nil {
+		slog.Error("failed to encode shared state", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return nil
+	}
+	if state.Counters, err = w.counters.State(); err != nil {
+		slog.Error("failed to encode counters", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return nil
+	}
+	state.Lists = w.lists.All()
+	state.Epoch = w.epochClock.Now()
+	data, err := json.Marshal(state)
+	if err != nil {
+		slog.Error("failed to encode shared state", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return nil
+	}
+	return data
+}
+
+// MergeRemoteState implements memberlist.Delegate and merges another node's shared state.
+func (w *worker) MergeRemoteState(buf []byte, join bool) {
+	if len(buf) == 0 {
+		return
+	}
+	var state gossipState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		slog.Error("failed to decode shared state", slog.String("hostname", w.hostname), slog.Any("err", err))
+		return
+	}
+	w.epochClock.Observe(state.Epoch)
+	if len(state.KV) > 0 {
+		stats, err := w.kv.Merge(state.KV)
+		if err != nil {
+			slog.Error("failed to merge shared state", slog.String("hostname", w.hostname), slog.Any("err", err))
+			return
+		}
+		w.metrics.kvMerged.Add(float64(stats.Updated), "updated")
+		w.metrics.kvMerged.Add(float64(stats.Rejected), "rejected")
+		if stats.Rejected > 0 {
+			slog.Warn("rejected shared state entries", slog.String("hostname", w.hostname), slog.Int("rejected", stats.Rejected))
+		}
+	}
+	for _, l := range state.Lists {
+		if w.lists.Merge(l) {
+			w.metrics.entityListReceived.Inc("push-pull")
+		}
+	}
+	if len(state.Counters) > 0 {
+		if _, err := w.counters.Merge(state.Counters); err != nil {
+			slog.Error("failed to merge counters", slog.String("hostname", w.hostname), slog.Any("err", err))
+		}
+	}
+}
+
+// counterStatus is the admin representation of a cluster-wide counter.
+type counterStatus struct {
+	Value int64 `json:"value"`
+	// Slots are the contributions of every worker process, by hostname and start time.
+	Slots map[string]int64 `json:"slots"`
+}
+
+// kvEntry is the admin representation of a shared state entry.
+type kvEntry struct {
+	Value     string             `json:"value"`
+	Timestamp gossipkv.Timestamp `json:"timestamp"`
+	WrittenAt time.Time          `json:"writtenAt"`
+}
+
+// newKVEntry returns the admin representation of an entry, with
+// values that aren't utf-8 text quoted as go strings.
+func newKVEntry(e gossipkv.Entry) kvEntry {
+	value := string(e.Value)
+	if !utf8.Valid(e.Value) {
+		value = fmt.Sprintf("%q", e.Value)
+	}
+	return kvEntry{
+		Value:     value,
+		Timestamp: e.Timestamp,
+		WrittenAt: e.Timestamp.Time().UTC(),
+	}
+}
+
+// registerKVHandlers adds the shared state admin endpoints, with
+// writes on the control mux.
+func (w *worker) registerKVHandlers(mux, control *http.ServeMux) {
+	mux.HandleFunc("GET /admin/kv", func(rw http.ResponseWriter, req *http.Request) {
+		output := make(map[string]kvEntry)
+		for key, e := range w.kv.Entries(req.URL.Query().Get("prefix")) {
+			output[key] = newKVEntry(e)
+		}
+		writeJSON(rw, http.StatusOK, output)
+	})
+	mux.HandleFunc("GET /admin/kv/{key...}", func(rw http.ResponseWriter, req *http.Request) {
+		value, ok := w.kv.Get(req.PathValue("key"))
+		if !ok {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = rw.Write(value)
+	})
+	control.HandleFunc("PUT /admin/kv/{key...}", func(rw http.ResponseWriter, req *http.Request) {
+		value, err := io.ReadAll(io.LimitReader(req.Body, gossipkv.MaxValueSize+1))
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("failed to read value: %v", err), http.StatusBadRequest)
+			return
+		}
+		e, err := w.kv.Set(req.PathValue("key"), value)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(rw, http.StatusOK, newKVEntry(e))
+	})
+	control.HandleFunc("DELETE /admin/kv/{key...}", func(rw http.ResponseWriter, req *http.Request) {
+		w.kv.Delete(req.PathValue("key"))
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/counters", func(rw http.ResponseWriter, req *http.Request) {
+		output := make(map[string]counterStatus)
+		for name, slots := range w.counters.Slots() {
+			output[name] = counterStatus{Value: w.counters.Value(name), Slots: slots}
+		}
+		writeJSON(rw, http.StatusOK, output)
+	})
+}
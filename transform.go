@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"gossip/pkg/pipeline"
+	"gossip/pkg/types"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// dryRunMu serializes dry run output from concurrent batches.
+var dryRunMu sync.Mutex
+
+// transform runs fetched values through the pipeline, if one is configured.
+//
+// The values kept are returned by fetched entity id, such that state kept
+// per entity follows the entity regardless of renames, along with the
+// entity names they're pushed under, for the entities that were renamed.
+//
+// In dry run mode the effect on every value is printed to stdout as
+// newline delimited json, and the values are returned unchanged.
+func (w *worker) transform(values map[string]int64) (output map[string]int64, names map[string]string) {
+	if w.pipeline == nil {
+		return values, nil
+	}
+	kept, effects := w.pipeline.Apply(time.Now(), values, w.entityInfo)
+	if !*pipelineDryRun {
+		w.metrics.pipelineDropped.Add(float64(len(values) - len(kept)))
+		w.logCollisions(effects)
+		output = make(map[string]int64, len(kept))
+		for _, e := range effects {
+			if e.DroppedBy != "" {
+				continue
+			}
+			output[e.ID] = e.Output
+			if e.Entity != e.ID {
+				if names == nil {
+					names = make(map[string]string)
+				}
+				names[e.ID] = e.Entity
+			}
+		}
+		return output, names
+	}
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	enc := json.NewEncoder(os.Stdout)
+	for _, effect := range effects {
+		_ = enc.Encode(effect)
+	}
+	slog.Info("pipeline dry run", slog.String("hostname", w.hostname), slog.Int("input-count", len(values)), slog.Int("output-count", len(kept)))
+	return values, nil
+}
+
+// entityName returns the entity name a value fetched for an id is pushed under.
+func entityName(names map[string]string, id string) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return id
+}
+
+// byEntityName returns values by the entity names they're pushed under.
+func byEntityName(values map[string]int64, names map[string]string) map[string]int64 {
+	if len(names) == 0 {
+		return values
+	}
+	output := make(map[string]int64, len(values))
+	for id, value := range values {
+		output[entityName(names, id)] = value
+	}
+	return output
+}
+
+// logCollisions logs the values the pipeline dropped for being renamed
+// to the same entity as another value.
+func (w *worker) logCollisions(effects []pipeline.Effect) {
+	for _, e := range effects {
+		if e.DroppedBy == pipeline.DroppedByCollision {
+			slog.Warn("pipeline renamed values to the same entity", slog.String("hostname", w.hostname), slog.String("entity", e.Entity), slog.String("id", e.ID), slog.String("kept-id", e.CollidesWith))
+		}
+	}
+}
+
+// entityInfo returns the data-plane metadata for an entity id, if it has been fetched.
+func (w *worker) entityInfo(id string) types.EntityInfo {
+	return w.infos[id]
+}
+
+// needsInfo returns if the poll config or the pipeline depend on entity metadata.
+func (w *worker) needsInfo() bool {
+	return w.pollConfig.NeedsInfo() || w.pipeline.NeedsInfo()
+}
+
+// loadPipeline loads the pipeline, if one is configured.
+func (w *worker) loadPipeline() (*pipeline.Pipeline, error) {
+	if *pipelineFile == "" {
+		return nil, nil
+	}
+	p, err := pipeline.Load(*pipelineFile)
+	if err != nil {
+		return nil, err
+	}
+	p.OnExecError = func(command string, err error) {
+		slog.Warn("pipeline exec stage failed", slog.String("hostname", w.hostname), slog.String("command", command), slog.Any("err", err))
+		w.metrics.pipelineExecErrors.Inc(command)
+	}
+	return p, nil
+}
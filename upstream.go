@@ -0,0 +1,30 @@
+package main
+
+import (
+	"gossip/pkg/upstream"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// newUpstreamClient returns a client for a named upstream that records
+// attempt latencies and circuit breaker state in the worker metrics.
+func (w *worker) newUpstreamClient(name string) *upstream.Client {
+	return &upstream.Client{
+		Name:           name,
+		HTTP:           &http.Client{},
+		AttemptTimeout: *upstreamTimeout,
+		Retry: upstream.RetryPolicy{
+			MaxAttempts: *upstreamAttempts,
+		},
+		Breaker: &upstream.Breaker{
+			OnStateChange: func(from, to upstream.BreakerState) {
+				slog.Warn("upstream circuit breaker state changed", slog.String("hostname", w.hostname), slog.String("upstream", name), slog.String("from", from.String()), slog.String("to", to.String()))
+				w.metrics.upstreamCircuitState.Set(float64(to), name)
+			},
+		},
+		Observer: func(upstream string, elapsed time.Duration, statusCode int, err error) {
+			w.metrics.upstreamDuration.Observe(elapsed.Seconds(), upstream, result(err))
+		},
+	}
+}